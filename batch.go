@@ -19,7 +19,13 @@ type WriteBatch struct {
 	options       WriteBatchOptions
 	mu            *sync.Mutex
 	db            *DB
-	pendingWrites map[string]*data.LogRecord
+	pendingWrites map[batchKey]*data.LogRecord
+}
+
+// 暂存数据使用的 key，不同命名空间中相同的 key 互不影响
+type batchKey struct {
+	namespace uint32
+	key       string
 }
 
 // NewWriteBatch 初始化 WriteBatch 结构体
@@ -33,7 +39,7 @@ func (db *DB) NewWriteBatch(options WriteBatchOptions) *WriteBatch {
 		options:       options,
 		mu:            new(sync.Mutex),
 		db:            db,
-		pendingWrites: make(map[string]*data.LogRecord),
+		pendingWrites: make(map[batchKey]*data.LogRecord),
 	}
 }
 
@@ -46,7 +52,23 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	defer wb.mu.Unlock()
 
 	logRecord := &data.LogRecord{Key: key, Value: value}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites[batchKey{key: string(key)}] = logRecord
+	return nil
+}
+
+// PutNamespace 在命名空间中写入数据，和其他命名空间的写入一起原子提交
+func (wb *WriteBatch) PutNamespace(ns *Namespace, key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ns.dropped.Load() {
+		return ErrNamespaceDropped
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	logRecord := &data.LogRecord{Key: key, Value: value, Namespace: ns.id}
+	wb.pendingWrites[batchKey{namespace: ns.id, key: string(key)}] = logRecord
 	return nil
 }
 
@@ -61,13 +83,38 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	logRecordPos := wb.db.index.Get(key)
 	if logRecordPos == nil {
 		// 先判断是否存在 nil
-		if wb.pendingWrites[string(key)] != nil {
-			delete(wb.pendingWrites, string(key))
+		if wb.pendingWrites[batchKey{key: string(key)}] != nil {
+			delete(wb.pendingWrites, batchKey{key: string(key)})
 		}
 		return nil
 	}
 	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites[batchKey{key: string(key)}] = logRecord
+	return nil
+}
+
+// DeleteNamespace 删除命名空间中的数据，和其他命名空间的写入一起原子提交
+func (wb *WriteBatch) DeleteNamespace(ns *Namespace, key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wb.db.mu.RLock()
+	if ns.dropped.Load() {
+		wb.db.mu.RUnlock()
+		return ErrNamespaceDropped
+	}
+	logRecordPos := ns.index.Get(key)
+	wb.db.mu.RUnlock()
+
+	bk := batchKey{namespace: ns.id, key: string(key)}
+	if logRecordPos == nil {
+		delete(wb.pendingWrites, bk)
+		return nil
+	}
+	wb.pendingWrites[bk] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted, Namespace: ns.id}
 	return nil
 }
 
@@ -82,25 +129,63 @@ func (wb *WriteBatch) Commit() error {
 	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// 需要持久化时和其他并发的写入一起组提交
+	if wb.options.SyncWrites || wb.db.options.SyncWrites {
+		return wb.db.groupCommit(wb.write)
+	}
 
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
+	return wb.commit()
+}
+
+// 写入暂存的数据并更新内存索引，在访问方法之前必须同时持有 wb.mu 和 db.mu
+func (wb *WriteBatch) commit() error {
+	apply, err := wb.write()
+	if err != nil {
+		return err
+	}
+
+	// 根据配置持久化
+	if wb.options.SyncWrites {
+		if err := wb.db.syncActiveFiles(); err != nil {
+			return err
+		}
+	}
+	apply()
+	return nil
+}
+
+// 写入暂存的数据，返回持久化之后更新内存索引的方法，在访问方法之前必须同时持有 wb.mu 和 db.mu
+func (wb *WriteBatch) write() (func(), error) {
+	// 命名空间已经被删除时不写入任何数据
+	for bk := range wb.pendingWrites {
+		if wb.db.indexOf(bk.namespace) == nil {
+			return nil, ErrNamespaceDropped
+		}
+	}
+
 	// 获取序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
 
 	// 开始写数据
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
+	positions := make(map[batchKey]*data.LogRecordPos)
+	for bk, record := range wb.pendingWrites {
 		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+			Key:       logRecordKeyWithSeq(record.Key, seqNo),
+			Value:     record.Value,
+			Type:      record.Type,
+			Namespace: record.Namespace,
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		positions[string(record.Key)] = logRecordPos
+		positions[bk] = logRecordPos
 	}
 
 	// 写标识事务完成的数据
@@ -109,35 +194,44 @@ func (wb *WriteBatch) Commit() error {
 		Type: data.LogRecordTxnFinished,
 	}
 	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
-		return err
+		return nil, err
 	}
 
-	// 根据配置持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
-		}
-	}
+	return func() {
+		wb.apply(seqNo, positions)
+	}, nil
+}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
+// 数据写入之后更新内存索引并通知订阅者，在访问方法之前必须同时持有 wb.mu 和 db.mu
+func (wb *WriteBatch) apply(seqNo uint64, positions map[batchKey]*data.LogRecordPos) {
+	// 更新内存索引，只有默认命名空间中的变更会更新二级索引和通知订阅者
+	for bk, record := range wb.pendingWrites {
+		pos := positions[bk]
+		idx := wb.db.indexOf(bk.namespace)
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos = idx.Put(record.Key, pos)
+			if bk.namespace == 0 {
+				wb.db.putSecondaryIndexes(record.Key, record.Value)
+				wb.db.notify(WatchPut, record.Key, record.Value, seqNo)
+			}
 		}
 		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			oldPos, _ = idx.Delete(record.Key)
+			// 删除记录本身也可以回收，和启动时加载索引的统计保持一致
+			wb.db.reclaim(pos)
+			if oldPos != nil && bk.namespace == 0 {
+				wb.db.deleteSecondaryIndexes(record.Key)
+				wb.db.notify(WatchDelete, record.Key, nil, seqNo)
+			}
 		}
 		if oldPos != nil {
-			wb.db.reclaimableSize += int64(oldPos.Size)
+			wb.db.reclaim(oldPos)
 		}
 	}
 
 	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
-	return nil
+	wb.pendingWrites = make(map[batchKey]*data.LogRecord)
 }
 
 // logRecordKeyWithSeq 将事务序列号 seqNo 和真实 key 打包成一个新的 key： [seqNo 的变长编码字节][原始 key 字节]
@@ -0,0 +1,91 @@
+package benchmark
+
+import (
+	"fmt"
+	"github.com/Nuyoahch/tinykv"
+	"github.com/Nuyoahch/tinykv/utils"
+	"os"
+	"sync"
+	"testing"
+)
+
+// Get 不获取数据库的锁，并发的读取只在索引自身的锁上竞争
+// 同时有写入时，读取也不会被写入持有的数据库锁阻塞
+
+var readBenchGoroutines = []int{1, 2, 4, 8, 16, 32, 64}
+
+// 预先写入的 key 数量
+const readBenchKeyNum = 10000
+
+func openReadDB(b *testing.B, indexType tinykv.IndexerType) *tinykv.DB {
+	options := tinykv.DefaultOptions
+	options.DirPath, _ = os.MkdirTemp("", "bitcask-go-bench-read")
+	options.IndexType = indexType
+	readDB, err := tinykv.Open(options)
+	if err != nil {
+		b.Fatalf("failed to open db: %v", err)
+	}
+	b.Cleanup(func() {
+		_ = readDB.Close()
+		_ = os.RemoveAll(options.DirPath)
+	})
+	for i := 0; i < readBenchKeyNum; i++ {
+		if err := readDB.Put(utils.GetTestKey(i), utils.RandomValue(128)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return readDB
+}
+
+func benchmarkConcurrentGet(b *testing.B, withWriter bool) {
+	indexTypes := []struct {
+		name string
+		typ  tinykv.IndexerType
+	}{
+		{"btree", tinykv.BTree},
+		{"art", tinykv.ART},
+		{"hash", tinykv.Hash},
+	}
+	for _, it := range indexTypes {
+		for _, n := range readBenchGoroutines {
+			b.Run(fmt.Sprintf("%s/goroutines-%d", it.name, n), func(b *testing.B) {
+				readDB := openReadDB(b, it.typ)
+
+				// 后台持续覆盖写入已有的 key
+				stop := make(chan struct{})
+				var wg sync.WaitGroup
+				if withWriter {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						value := utils.RandomValue(128)
+						for i := 0; ; i++ {
+							select {
+							case <-stop:
+								return
+							default:
+							}
+							_ = readDB.Put(utils.GetTestKey(i%readBenchKeyNum), value)
+						}
+					}()
+				}
+
+				runConcurrently(b, n, func(i int) error {
+					_, err := readDB.Get(utils.GetTestKey(i % readBenchKeyNum))
+					return err
+				})
+				b.StopTimer()
+				close(stop)
+				wg.Wait()
+			})
+		}
+	}
+}
+
+func Benchmark_ConcurrentGet(b *testing.B) {
+	benchmarkConcurrentGet(b, false)
+}
+
+func Benchmark_ConcurrentGetWithWriter(b *testing.B) {
+	benchmarkConcurrentGet(b, true)
+}
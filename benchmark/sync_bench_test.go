@@ -0,0 +1,106 @@
+package benchmark
+
+import (
+	"fmt"
+	"github.com/Nuyoahch/tinykv"
+	"github.com/Nuyoahch/tinykv/utils"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 开启 SyncWrites 之后，并发的写入通过组提交合并为一次写入和一次持久化
+// 并发的 goroutine 越多，每次写入平均的耗时越短
+
+var syncBenchGoroutines = []int{1, 8, 64}
+
+func openSyncDB(b *testing.B) *tinykv.DB {
+	options := tinykv.DefaultOptions
+	options.DirPath, _ = os.MkdirTemp("", "bitcask-go-bench-sync")
+	options.SyncWrites = true
+	syncDB, err := tinykv.Open(options)
+	if err != nil {
+		b.Fatalf("failed to open db: %v", err)
+	}
+	b.Cleanup(func() {
+		_ = syncDB.Close()
+		_ = os.RemoveAll(options.DirPath)
+	})
+	return syncDB
+}
+
+// 使用 n 个 goroutine 一共执行 b.N 次操作
+func runConcurrently(b *testing.B, n int, op func(i int) error) {
+	var next int64 = -1
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	b.ReportAllocs()
+	for g := 0; g < n; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= b.N {
+					return
+				}
+				if err := op(i); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Benchmark_SyncPut(b *testing.B) {
+	for _, n := range syncBenchGoroutines {
+		b.Run(fmt.Sprintf("goroutines-%d", n), func(b *testing.B) {
+			syncDB := openSyncDB(b)
+			value := utils.RandomValue(1024)
+			runConcurrently(b, n, func(i int) error {
+				return syncDB.Put(utils.GetTestKey(i), value)
+			})
+		})
+	}
+}
+
+func Benchmark_SyncDelete(b *testing.B) {
+	for _, n := range syncBenchGoroutines {
+		b.Run(fmt.Sprintf("goroutines-%d", n), func(b *testing.B) {
+			syncDB := openSyncDB(b)
+			wb := syncDB.NewWriteBatch(tinykv.WriteBatchOptions{MaxBatchNum: uint(b.N) + 1})
+			for i := 0; i < b.N; i++ {
+				if err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := wb.Commit(); err != nil {
+				b.Fatal(err)
+			}
+			runConcurrently(b, n, func(i int) error {
+				return syncDB.Delete(utils.GetTestKey(i))
+			})
+		})
+	}
+}
+
+func Benchmark_SyncWriteBatch(b *testing.B) {
+	for _, n := range syncBenchGoroutines {
+		b.Run(fmt.Sprintf("goroutines-%d", n), func(b *testing.B) {
+			syncDB := openSyncDB(b)
+			value := utils.RandomValue(1024)
+			runConcurrently(b, n, func(i int) error {
+				wb := syncDB.NewWriteBatch(tinykv.DefaultWriteBatchOptions)
+				for j := 0; j < 10; j++ {
+					if err := wb.Put(utils.GetTestKey(i*10+j), value); err != nil {
+						return err
+					}
+				}
+				return wb.Commit()
+			})
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/fio"
+	"github.com/Nuyoahch/tinykv/index"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 较大的 value 单独写入 blob 文件，数据文件中的记录只保存 blob 记录的位置
+// blob 记录的 key 为用户的 key，回收空间时用来判断记录是否仍然有效
+
+// MergeBlobs 回收 blob 文件中的无效数据
+// 仍然有效的 blob 记录会被重写到新的 blob 文件中，同时在数据文件中写入指向新位置的记录，之后删除旧的 blob 文件
+// 可回收空间的比例没有达到 BlobMergeRatio 时返回 ErrMergeRatioUnreached
+func (db *DB) MergeBlobs() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	if db.activeBlobFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	// 和数据文件的 merge 不能同时进行
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	db.isMerging = true
+	defer func() {
+		db.isMerging = false
+	}()
+
+	// 已经过期的 key 不再需要保留，从索引中移除并计入可回收的空间
+	db.removeExpiredKeys()
+
+	totalSize := db.blobFilesSize()
+	if totalSize == 0 || float32(db.blobReclaimableSize)/float32(totalSize) < db.options.BlobMergeRatio {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+
+	var mergeFiles []*data.DataFile
+	for _, file := range db.blobFiles {
+		mergeFiles = append(mergeFiles, file)
+	}
+	return db.mergeBlobFiles(mergeFiles)
+}
+
+// 使用当前的密钥重新加密 blob 文件，只重写包含其他密钥加密的记录的 blob 文件
+// merge 重写数据文件之后调用，调用方需要已经设置了 isMerging
+func (db *DB) rotateBlobFiles() error {
+	currentId, err := db.cipher.CurrentKeyId()
+	if err != nil {
+		return err
+	}
+	// 包含其他密钥加密的记录时需要重写
+	stale := func(file *data.DataFile) (bool, error) {
+		ids, err := file.KeyIds()
+		if err != nil {
+			return false, err
+		}
+		for id := range ids {
+			if id != currentId {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	db.mu.Lock()
+	if db.activeBlobFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	var olderFiles []*data.DataFile
+	for fid, file := range db.blobFiles {
+		if fid != db.activeBlobFile.FileId {
+			olderFiles = append(olderFiles, file)
+		}
+	}
+	db.mu.Unlock()
+
+	// 旧的 blob 文件不会再写入，读取时不需要持有锁，活跃 blob 文件需要在锁内检查
+	var mergeFiles []*data.DataFile
+	for _, file := range olderFiles {
+		ok, err := stale(file)
+		if err != nil {
+			return err
+		}
+		if ok {
+			mergeFiles = append(mergeFiles, file)
+		}
+	}
+
+	db.mu.Lock()
+	ok, err := stale(db.activeBlobFile)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if ok {
+		mergeFiles = append(mergeFiles, db.activeBlobFile)
+	}
+	if len(mergeFiles) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	return db.mergeBlobFiles(mergeFiles)
+}
+
+// 重写 mergeFiles 中仍然有效的记录，之后删除这些 blob 文件
+// 调用时必须持有互斥锁，返回前会释放
+func (db *DB) mergeBlobFiles(mergeFiles []*data.DataFile) error {
+	// 参与 merge 的 blob 文件之后不会再写入，包含活跃 blob 文件时先持久化并切换
+	for _, file := range mergeFiles {
+		if file != db.activeBlobFile {
+			continue
+		}
+		if err := db.activeBlobFile.Sync(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		if err := db.setActiveBlobFile(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		break
+	}
+	db.mu.Unlock()
+
+	sort.Slice(mergeFiles, func(i, j int) bool {
+		return mergeFiles[i].FileId < mergeFiles[j].FileId
+	})
+
+	// 参与 merge 的 blob 文件中除了被重写的记录，其余的空间都被回收
+	var reclaimedSize int64
+	for _, blobFile := range mergeFiles {
+		movedSize, err := db.mergeBlobFile(blobFile)
+		if err != nil {
+			return err
+		}
+		reclaimedSize += blobFile.WriteOff - blobFile.HeaderSize - movedSize
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 指向新位置的记录持久化之后才能删除旧的 blob 文件
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+	db.beginRemapFiles()
+	defer db.endRemapFiles()
+	for _, blobFile := range mergeFiles {
+		delete(db.blobFiles, blobFile.FileId)
+		db.retireDataFile(blobFile)
+		if err := os.Remove(data.GetBlobFileName(db.options.DirPath, blobFile.FileId)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	db.blobReclaimableSize -= reclaimedSize
+	if db.blobReclaimableSize < 0 {
+		db.blobReclaimableSize = 0
+	}
+	return nil
+}
+
+// 重写一个 blob 文件中仍然有效的记录，返回被重写的记录在旧文件中的大小
+func (db *DB) mergeBlobFile(blobFile *data.DataFile) (int64, error) {
+	var movedSize int64
+	offset := blobFile.HeaderSize
+	for {
+		logRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			// 文件末尾不完整的记录是写入时中断留下的，数据文件中不会有指向它的记录
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			// 损坏的记录已经无法读取，不再保留
+			if err == data.ErrInvalidCRC && size > 0 {
+				offset += size
+				continue
+			}
+			return 0, err
+		}
+		moved, err := db.moveBlob(logRecord, blobFile.FileId, offset)
+		if err != nil {
+			return 0, err
+		}
+		if moved {
+			movedSize += size
+		}
+		offset += size
+	}
+	return movedSize, nil
+}
+
+// 如果 key 仍然指向这条 blob 记录，将其重写到活跃 blob 文件中，并在数据文件中写入指向新位置的记录
+func (db *DB) moveBlob(blobRecord *data.LogRecord, fid uint32, offset int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	idx := db.indexOf(blobRecord.Namespace)
+	if idx == nil {
+		return false, nil
+	}
+	pos := idx.Get(blobRecord.Key)
+	if pos == nil || pos.BlobSize == 0 || pos.IsExpired(time.Now().UnixNano()) {
+		return false, nil
+	}
+	// 读取数据文件中的记录，确认仍然指向这条 blob 记录
+	dataFile := db.olderFiles[pos.Fid]
+	if db.activeFile != nil && db.activeFile.FileId == pos.Fid {
+		dataFile = db.activeFile
+	}
+	if dataFile == nil {
+		return false, ErrDataFileNotFound
+	}
+	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return false, err
+	}
+	if !logRecord.Blob {
+		return false, nil
+	}
+	if blobPos := data.DecodeLogRecordPos(logRecord.Value); blobPos.Fid != fid || blobPos.Offset != offset {
+		return false, nil
+	}
+
+	blobPos, err := db.writeBlob(blobRecord.Key, blobRecord.Value, blobRecord.Namespace)
+	if err != nil {
+		return false, err
+	}
+	newPos, err := db.appendLogRecord(&data.LogRecord{
+		Key:       logRecordKeyWithSeq(blobRecord.Key, nonTransactionSeqNo),
+		Value:     data.EncodeLogRecordPos(blobPos),
+		Type:      data.LogRecordNormal,
+		Expire:    pos.Expire,
+		Blob:      true,
+		Namespace: blobRecord.Namespace,
+	})
+	if err != nil {
+		return false, err
+	}
+	// 旧的 blob 记录在 merge 完成之后统一回收，这里只统计数据文件中的部分
+	if oldPos := idx.Put(blobRecord.Key, newPos); oldPos != nil {
+		db.reclaimableSize += int64(oldPos.Size)
+	}
+	return true, nil
+}
+
+// 将 value 写入到活跃 blob 文件中，返回 blob 记录的位置，在访问方法之前必须持有互斥锁
+// blob 记录中保存 key 所属的命名空间，回收 blob 文件时用于查找索引
+func (db *DB) writeBlob(key []byte, value []byte, namespace uint32) (*data.LogRecordPos, error) {
+	if db.activeBlobFile == nil {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 和数据文件中的记录一样先压缩再加密
+	logRecord, err := data.CompressLogRecord(&data.LogRecord{Key: key, Value: value, Namespace: namespace}, db.options.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if db.cipher != nil {
+		if logRecord, err = db.cipher.EncryptLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+	}
+	encRecord, size := data.EncodeLogRecord(logRecord)
+
+	// 活跃 blob 文件写满之后打开新的文件
+	if db.activeBlobFile.WriteOff+size > db.options.BlobFileSize {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return nil, err
+		}
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	return &data.LogRecordPos{Fid: db.activeBlobFile.FileId, Offset: writeOff, Size: uint32(size)}, nil
+}
+
+// 设置当前活跃 blob 文件，在访问方法之前必须持有互斥锁
+func (db *DB) setActiveBlobFile() error {
+	var fileId uint32 = 0
+	if db.activeBlobFile != nil {
+		fileId = db.activeBlobFile.FileId + 1
+	}
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, fileId, fio.StandardFile)
+	if err != nil {
+		return err
+	}
+	blobFile.Cipher = db.cipher
+	db.blobFiles[fileId] = blobFile
+	db.activeBlobFile = blobFile
+	db.publishFileTable()
+	return nil
+}
+
+// 从磁盘中加载 blob 文件，id 最大的作为活跃 blob 文件继续写入
+func (db *DB) loadBlobFiles() error {
+	fileIds, err := listBlobFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, uint32(fid), db.fileIOType())
+		if err != nil {
+			return err
+		}
+		blobFile.Cipher = db.cipher
+		db.blobFiles[uint32(fid)] = blobFile
+		db.activeBlobFile = blobFile
+		// blob 文件中的记录通过数据文件定位，不需要遍历，直接从文件末尾继续写入
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		blobFile.WriteOff = size
+	}
+	return nil
+}
+
+// 查找目录中所有的 blob 文件 id，按照从小到大的顺序返回
+func listBlobFileIds(dirPath string) ([]int, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.BlobFileNameSuffix))
+		if err != nil {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		fileIds = append(fileIds, fileId)
+	}
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
+// 计算 blob 文件中可回收的空间，即 blob 文件中的数据总量减去仍然被索引引用的部分
+// 写入过程中中断留下的 blob 记录也会被统计在内
+func (db *DB) loadBlobReclaimableSize() {
+	if len(db.blobFiles) == 0 {
+		return
+	}
+	var size int64
+	for _, blobFile := range db.blobFiles {
+		size += blobFile.WriteOff - blobFile.HeaderSize
+	}
+	indexes := []index.Indexer{db.index}
+	for _, ns := range db.namespaces {
+		indexes = append(indexes, ns.index)
+	}
+	for _, idx := range indexes {
+		iterator := idx.Iterator(false)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			size -= int64(iterator.Value().BlobSize)
+		}
+		iterator.Close()
+	}
+	if size < 0 {
+		size = 0
+	}
+	db.blobReclaimableSize = size
+}
+
+// 所有 blob 文件的大小，在访问方法之前必须持有互斥锁
+func (db *DB) blobFilesSize() int64 {
+	var size int64
+	for _, blobFile := range db.blobFiles {
+		size += blobFile.WriteOff
+	}
+	return size
+}
+
+// 引用当前所有的 blob 文件，在访问方法之前必须持有互斥锁
+func (db *DB) acquireBlobFiles() map[uint32]*data.DataFile {
+	files := make(map[uint32]*data.DataFile, len(db.blobFiles))
+	for fid, file := range db.blobFiles {
+		files[fid] = file
+		db.fileRefs[file]++
+	}
+	return files
+}
+
+// 根据数据文件中记录的位置，从 blob 文件中读取 value
+func getValueFromBlobFile(blobFiles map[uint32]*data.DataFile, value []byte) ([]byte, error) {
+	blobPos := data.DecodeLogRecordPos(value)
+	blobFile := blobFiles[blobPos.Fid]
+	if blobFile == nil {
+		return nil, ErrBlobFileNotFound
+	}
+	logRecord, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return logRecord.Value, nil
+}
@@ -0,0 +1,170 @@
+package tinykv
+
+import (
+	"bytes"
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Blob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob")
+	opts.DirPath = dir
+	opts.BlobThreshold = 1024
+	opts.BlobFileSize = 256 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 较小的 value 仍然写在数据文件中
+	small := utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(0), small)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0), db.Stat().BlobFileNum)
+
+	large := bytes.Repeat([]byte("blob-value"), 1000)
+	for i := 1; i <= 100; i++ {
+		err := db.Put(utils.GetTestKey(i), large)
+		assert.Nil(t, err)
+	}
+	stat := db.Stat()
+	assert.True(t, stat.BlobFileNum > 1)
+	assert.Equal(t, int64(0), stat.BlobReclaimableSize)
+
+	// 数据文件中只保存 blob 记录的位置
+	content, err := os.ReadFile(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, large))
+
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+
+	// 覆盖和删除之后 blob 文件中产生可回收的空间
+	for i := 1; i <= 80; i++ {
+		err := db.Put(utils.GetTestKey(i), small)
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(81))
+	assert.Nil(t, err)
+	assert.True(t, db.Stat().BlobReclaimableSize > 0)
+
+	// 重启之后根据索引重新计算
+	reclaimable := db.Stat().BlobReclaimableSize
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimable, db.Stat().BlobReclaimableSize)
+
+	// 数据文件 merge 不会重写 blob 文件
+	blobFileNum := db.Stat().BlobFileNum
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, blobFileNum, db.Stat().BlobFileNum)
+	assert.Equal(t, reclaimable, db.Stat().BlobReclaimableSize)
+	val, err = db.Get(utils.GetTestKey(82))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+
+	// 回收 blob 文件中的空间
+	diskSize := db.Stat().DiskSize
+	err = db.MergeBlobs()
+	assert.Nil(t, err)
+	stat = db.Stat()
+	assert.Equal(t, int64(0), stat.BlobReclaimableSize)
+	assert.True(t, stat.BlobFileNum < blobFileNum)
+	assert.True(t, stat.DiskSize < diskSize)
+
+	check := func(db *DB) {
+		for i := 0; i <= 80; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, small, val)
+		}
+		_, err := db.Get(utils.GetTestKey(81))
+		assert.Equal(t, ErrKeyNotFound, err)
+		for i := 82; i <= 100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, large, val)
+		}
+	}
+	check(db)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db.Stat().BlobReclaimableSize)
+	check(db)
+
+	// 没有可回收的空间
+	err = db.MergeBlobs()
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+}
+
+func TestDB_MergeBlobs_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-snapshot")
+	opts.DirPath = dir
+	opts.BlobThreshold = 512
+	opts.BlobFileSize = 64 * 1024
+	opts.BlobMergeRatio = 0
+	opts.Compression = SnappyCompression
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(1024)
+		err := wb.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	assert.True(t, db.Stat().BlobFileNum > 0)
+
+	snapshot := db.NewSnapshot()
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.MergeBlobs()
+	assert.Nil(t, err)
+
+	// 快照和迭代器仍然可以读取旧的 blob 文件
+	for i := 0; i < 100; i++ {
+		val, err := snapshot.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		val, err := iterator.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+		count++
+	}
+	assert.Equal(t, 100, count)
+	iterator.Close()
+	snapshot.Release()
+
+	for i := 50; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+}
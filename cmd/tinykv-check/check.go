@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/fio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// B+ 树索引文件名称，索引中保存了数据的位置，数据文件被重写之后会失效
+const bptreeIndexFileName = "bptree-index"
+
+var errTrailingZeros = errors.New("unexpected zero bytes at the end of file")
+
+var errInvalidSeqNo = errors.New("invalid transaction sequence number in record key")
+
+// 文件中的一段数据
+type span struct {
+	offset int64
+	size   int64
+}
+
+// 文件中损坏的一段数据
+type corruptSpan struct {
+	span
+	err error
+}
+
+// 单个文件的检查结果
+type fileReport struct {
+	name       string
+	isData     bool          // 是否是数据文件
+	isBlob     bool          // 是否是 blob 文件
+	fid        uint32        // 数据文件 id
+	version    uint16        // 文件格式版本
+	headerSize int64         // 文件头的长度
+	records    []span        // 有效的记录
+	corrupts   []corruptSpan // 损坏的数据
+	liveBytes  int64         // 仍然被索引引用的字节数
+}
+
+func (fr *fileReport) validBytes() int64 {
+	var size int64
+	for _, r := range fr.records {
+		size += r.size
+	}
+	return size
+}
+
+func (fr *fileReport) corruptBytes() int64 {
+	var size int64
+	for _, c := range fr.corrupts {
+		size += c.size
+	}
+	return size
+}
+
+// 记录在数据文件中的位置
+type location struct {
+	fid  uint32
+	size int64
+	blob *data.LogRecordPos // value 存放在 blob 文件中时，blob 记录的位置
+}
+
+// 记录的 key，不同命名空间中相同的 key 互不影响
+type recordKey struct {
+	namespace uint32
+	key       string
+}
+
+// 事务中尚未提交的记录
+type txnRecord struct {
+	key    recordKey
+	typ    data.LogRecordType
+	expire int64
+	loc    location
+}
+
+// 整个数据目录的检查结果
+type checkResult struct {
+	dirPath        string
+	files          []*fileReport
+	hasMerge       bool
+	nonMergeFileId uint32
+	incompleteTxns map[uint64]int // 没有 txn-fin 标记的事务序列号，以及其中的记录数
+}
+
+// 是否有损坏的文件，没有完成的事务在启动时会被忽略，不算作损坏
+func (cr *checkResult) damaged() bool {
+	for _, fr := range cr.files {
+		if len(fr.corrupts) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查数据目录中的数据文件、hint 索引文件和事务序列号文件
+func check(dirPath string) (*checkResult, error) {
+	cr := &checkResult{dirPath: dirPath, incompleteTxns: make(map[uint64]int)}
+
+	// 存在 merge 完成的文件时，记录参与 merge 的文件范围
+	if exists(filepath.Join(dirPath, data.MergeFinishedFileName)) {
+		fid, err := readNonMergeFileId(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		cr.hasMerge, cr.nonMergeFileId = true, fid
+	}
+
+	dataFileIds, err := fileIds(dirPath, data.DataFileNameSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	// 已经删除的命名空间中的记录不再有效
+	namespaces, err := readNamespaces(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按照文件 id 从小到大重放数据文件，计算仍然有效的记录
+	live := make(map[recordKey]location)
+	pending := make(map[uint64][]txnRecord)
+	now := time.Now().UnixNano()
+	apply := func(key recordKey, typ data.LogRecordType, expire int64, loc location) {
+		if key.namespace != 0 && !namespaces[key.namespace] {
+			return
+		}
+		if typ == data.LogRecordDeleted || (expire > 0 && expire <= now) {
+			delete(live, key)
+			return
+		}
+		live[key] = loc
+	}
+
+	reports := make(map[uint32]*fileReport)
+	for _, fid := range dataFileIds {
+		dataFile, err := data.OpenDataFile(dirPath, fid, fio.StandardFile)
+		if err != nil {
+			return nil, err
+		}
+		fr := &fileReport{name: filepath.Base(data.GetDataFileName(dirPath, fid)), isData: true, fid: fid}
+		err = scanFile(dataFile, fr, func(record *data.LogRecord, size int64) error {
+			// CRC 正确但是 key 中的序列号无法解析时视为损坏的记录
+			seqNo, n := binary.Uvarint(record.Key)
+			if n <= 0 {
+				return errInvalidSeqNo
+			}
+			loc := location{fid: fid, size: size}
+			if record.Blob {
+				loc.blob = data.DecodeLogRecordPos(record.Value)
+			}
+			realKey := recordKey{namespace: record.Namespace, key: string(record.Key[n:])}
+			switch {
+			case seqNo == 0:
+				apply(realKey, record.Type, record.Expire, loc)
+			case record.Type == data.LogRecordTxnFinished:
+				for _, r := range pending[seqNo] {
+					apply(r.key, r.typ, r.expire, r.loc)
+				}
+				delete(pending, seqNo)
+			default:
+				pending[seqNo] = append(pending[seqNo], txnRecord{key: realKey, typ: record.Type, expire: record.Expire, loc: loc})
+			}
+			return nil
+		})
+		_ = dataFile.Close()
+		if err != nil {
+			return nil, err
+		}
+		reports[fid] = fr
+		cr.files = append(cr.files, fr)
+	}
+	for seqNo, records := range pending {
+		cr.incompleteTxns[seqNo] = len(records)
+	}
+
+	// blob 文件中的记录通过数据文件定位，只检查记录是否完好
+	blobFileIds, err := fileIds(dirPath, data.BlobFileNameSuffix)
+	if err != nil {
+		return nil, err
+	}
+	blobReports := make(map[uint32]*fileReport)
+	for _, fid := range blobFileIds {
+		blobFile, err := data.OpenBlobFile(dirPath, fid, fio.StandardFile)
+		if err != nil {
+			return nil, err
+		}
+		fr := &fileReport{name: filepath.Base(data.GetBlobFileName(dirPath, fid)), isBlob: true, fid: fid}
+		err = scanFile(blobFile, fr, nil)
+		_ = blobFile.Close()
+		if err != nil {
+			return nil, err
+		}
+		blobReports[fid] = fr
+		cr.files = append(cr.files, fr)
+	}
+
+	for _, loc := range live {
+		reports[loc.fid].liveBytes += loc.size
+		if loc.blob != nil && blobReports[loc.blob.Fid] != nil {
+			blobReports[loc.blob.Fid].liveBytes += int64(loc.blob.Size)
+		}
+	}
+
+	// hint 索引文件和事务序列号文件
+	for _, name := range []string{data.HintFileName, data.SeqNoFileName} {
+		if !exists(filepath.Join(dirPath, name)) {
+			continue
+		}
+		var file *data.DataFile
+		if name == data.HintFileName {
+			file, err = data.OpenHintFile(dirPath)
+		} else {
+			file, err = data.OpenSeqNoFile(dirPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fr := &fileReport{name: name}
+		err = scanFile(file, fr, nil)
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+		cr.files = append(cr.files, fr)
+	}
+	return cr, nil
+}
+
+// 依次读取文件中的所有记录，跳过损坏的记录，无法确定长度时文件剩余的部分都视为损坏
+// fn 返回错误时这条记录也视为损坏
+func scanFile(file *data.DataFile, fr *fileReport, fn func(record *data.LogRecord, size int64) error) error {
+	fileSize, err := file.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	fr.version, fr.headerSize = file.Header.Version, file.HeaderSize
+	offset := file.HeaderSize
+	for offset < fileSize {
+		record, size, err := file.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				// 文件末尾剩余的全部是 0
+				fr.corrupts = append(fr.corrupts, corruptSpan{span{offset, fileSize - offset}, errTrailingZeros})
+				return nil
+			}
+			if err != data.ErrInvalidCRC && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			if size <= 0 {
+				size = fileSize - offset
+			}
+			fr.corrupts = append(fr.corrupts, corruptSpan{span{offset, size}, err})
+			offset += size
+			continue
+		}
+		if fn != nil {
+			if err := fn(record, size); err != nil {
+				fr.corrupts = append(fr.corrupts, corruptSpan{span{offset, size}, err})
+				offset += size
+				continue
+			}
+		}
+		fr.records = append(fr.records, span{offset, size})
+		offset += size
+	}
+	return nil
+}
+
+// 打印检查结果
+func (cr *checkResult) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FILE\tVERSION\tRECORDS\tLIVE BYTES\tDEAD BYTES\tCRC FAILURES\tCORRUPT BYTES")
+	for _, fr := range cr.files {
+		live, dead := "-", "-"
+		if fr.isData || fr.isBlob {
+			live = strconv.FormatInt(fr.liveBytes, 10)
+			dead = strconv.FormatInt(fr.validBytes()-fr.liveBytes, 10)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%d\t%d\n",
+			fr.name, fr.version, len(fr.records), live, dead, len(fr.corrupts), fr.corruptBytes())
+	}
+	_ = tw.Flush()
+
+	for _, fr := range cr.files {
+		for _, c := range fr.corrupts {
+			_, _ = fmt.Fprintf(w, "%s: %d bytes at offset %d: %v\n", fr.name, c.size, c.offset, c.err)
+		}
+	}
+
+	seqNos := make([]uint64, 0, len(cr.incompleteTxns))
+	for seqNo := range cr.incompleteTxns {
+		seqNos = append(seqNos, seqNo)
+	}
+	sort.Slice(seqNos, func(i, j int) bool { return seqNos[i] < seqNos[j] })
+	for _, seqNo := range seqNos {
+		_, _ = fmt.Fprintf(w, "incomplete transaction %d: %d records without txn-fin marker\n", seqNo, cr.incompleteTxns[seqNo])
+	}
+}
+
+// 修复损坏的文件，只保留有效的记录
+// 重写参与过 merge 的数据文件或者 hint 索引文件损坏时，删除 hint 索引文件和 merge 完成的文件，下次启动时从数据文件中重建索引
+func (cr *checkResult) repair(w io.Writer) error {
+	hasBPTreeIndex := exists(filepath.Join(cr.dirPath, bptreeIndexFileName))
+	var dropHint bool
+	for _, fr := range cr.files {
+		if len(fr.corrupts) == 0 {
+			continue
+		}
+		if fr.name == data.HintFileName {
+			dropHint = true
+			continue
+		}
+		if fr.isData && hasBPTreeIndex {
+			_, _ = fmt.Fprintf(w, "skip %s: the b+tree index would point to stale positions\n", fr.name)
+			continue
+		}
+		// 重写 blob 文件会改变记录的位置，数据文件中的记录会指向错误的位置
+		if fr.isBlob {
+			_, _ = fmt.Fprintf(w, "skip %s: data files would point to stale blob positions\n", fr.name)
+			continue
+		}
+		// 文件头和有效的记录一起保留
+		spans := fr.records
+		if fr.headerSize > 0 {
+			spans = append([]span{{0, fr.headerSize}}, spans...)
+		}
+		if err := rewriteFile(filepath.Join(cr.dirPath, fr.name), spans); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "rewrote %s: kept %d records, dropped %d bytes\n", fr.name, len(fr.records), fr.corruptBytes())
+		if fr.isData && cr.hasMerge && fr.fid < cr.nonMergeFileId {
+			dropHint = true
+		}
+	}
+
+	if dropHint {
+		for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+			if err := os.Remove(filepath.Join(cr.dirPath, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		_, _ = fmt.Fprintf(w, "removed %s and %s, the index will be rebuilt from data files\n", data.HintFileName, data.MergeFinishedFileName)
+	}
+	return nil
+}
+
+// 将有效的数据原样拷贝到临时文件中，再替换掉原来的文件
+func rewriteFile(fileName string, records []span) error {
+	src, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	tmpName := fileName + ".repair"
+	dst, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		buf := make([]byte, r.size)
+		if _, err := src.ReadAt(buf, r.offset); err != nil {
+			_ = dst.Close()
+			return err
+		}
+		if _, err := dst.Write(buf); err != nil {
+			_ = dst.Close()
+			return err
+		}
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// 读取所有指定后缀的文件 id，从小到大排序
+func fileIds(dirPath string, suffix string) ([]uint32, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []uint32
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		fid, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), suffix), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file name %s", entry.Name())
+		}
+		fileIds = append(fileIds, uint32(fid))
+	}
+	sort.Slice(fileIds, func(i, j int) bool { return fileIds[i] < fileIds[j] })
+	return fileIds, nil
+}
+
+// 读取 merge 完成文件中记录的没有参与 merge 的文件 id
+func readNonMergeFileId(dirPath string) (uint32, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	record, _, err := mergeFinishedFile.ReadLogRecord(mergeFinishedFile.HeaderSize)
+	if err != nil {
+		return 0, err
+	}
+	fid, err := strconv.ParseUint(string(record.Value), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(fid), nil
+}
+
+// 读取 namespaces 文件中仍然存在的命名空间 id
+func readNamespaces(dirPath string) (map[uint32]bool, error) {
+	namespaces := make(map[uint32]bool)
+	fileName := filepath.Join(dirPath, data.NamespaceFileName)
+	if !exists(fileName) {
+		return namespaces, nil
+	}
+	nsFile, err := data.OpenNamespaceFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = nsFile.Close()
+	}()
+	offset := nsFile.HeaderSize
+	for {
+		record, size, err := nsFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return namespaces, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.Namespace != 0 {
+			namespaces[record.Namespace] = true
+		}
+		offset += size
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Nuyoahch/tinykv"
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckAndRepair(t *testing.T) {
+	opts := tinykv.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-check")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := tinykv.Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	for i := 3000; i < 4000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 完好的数据目录
+	result, err := check(dir)
+	assert.Nil(t, err)
+	assert.False(t, result.damaged())
+	assert.True(t, result.hasMerge)
+	var liveBytes int64
+	for _, fr := range result.files {
+		liveBytes += fr.liveBytes
+	}
+	assert.True(t, liveBytes > 0)
+
+	// 修改 merge 之后第一个数据文件中第一条记录的内容
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xff}, data.FileHeaderSize+10)
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	// 活跃文件末尾有一个没有完成的事务，以及一条不完整的记录
+	dataFileIds, err := fileIds(dir, data.DataFileNameSuffix)
+	assert.Nil(t, err)
+	activeFileName := data.GetDataFileName(dir, dataFileIds[len(dataFileIds)-1])
+	seq := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(seq, 99)
+	txnRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: append(seq[:n], "txn-key"...), Value: []byte("txn-value")})
+	tornRecord, size := data.EncodeLogRecord(&data.LogRecord{Key: []byte{0, 'a'}, Value: utils.RandomValue(64)})
+	file, err = os.OpenFile(activeFileName, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(append(txnRecord, tornRecord[:size-5]...))
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	result, err = check(dir)
+	assert.Nil(t, err)
+	assert.True(t, result.damaged())
+	assert.Equal(t, map[uint64]int{99: 1}, result.incompleteTxns)
+	var corrupts []corruptSpan
+	for _, fr := range result.files {
+		corrupts = append(corrupts, fr.corrupts...)
+	}
+	assert.Equal(t, 2, len(corrupts))
+	assert.Equal(t, data.ErrInvalidCRC, corrupts[0].err)
+	assert.Equal(t, io.ErrUnexpectedEOF, corrupts[1].err)
+	assert.Equal(t, size-5, corrupts[1].size)
+
+	// 数据库正在使用时不能检查
+	_, err = tinykv.Open(opts)
+	assert.NotNil(t, err)
+	opts.RecoveryMode = tinykv.RecoverySkipCorrupt
+	db, err = tinykv.Open(opts)
+	assert.Nil(t, err)
+	_, err = run(dir, false)
+	assert.Equal(t, tinykv.ErrDatabaseIsUsing, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 修复之后再次检查没有损坏，并且可以在严格模式下打开
+	damaged, err := run(dir, true)
+	assert.Nil(t, err)
+	assert.True(t, damaged)
+	_, err = os.Stat(filepath.Join(dir, data.HintFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	result, err = check(dir)
+	assert.Nil(t, err)
+	assert.False(t, result.damaged())
+
+	opts.RecoveryMode = tinykv.RecoveryStrict
+	db, err = tinykv.Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	_, err = db.Get(utils.GetTestKey(1000))
+	assert.Equal(t, tinykv.ErrKeyNotFound, err)
+	for i := 1001; i < 4000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestCheck_InvalidSeqNo(t *testing.T) {
+	opts := tinykv.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-check-seq-no")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	opts.DirPath = dir
+	db, err := tinykv.Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	// CRC 正确，但是 key 开头的序列号超出了 uint64 的范围
+	key := append(bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64), 0x01, 'k')
+	record, size := data.EncodeLogRecord(&data.LogRecord{Key: key, Value: []byte("value")})
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(record)
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	result, err := check(dir)
+	assert.Nil(t, err)
+	assert.True(t, result.damaged())
+	assert.Equal(t, 1, len(result.files[0].corrupts))
+	assert.Equal(t, errInvalidSeqNo, result.files[0].corrupts[0].err)
+	assert.Equal(t, size, result.files[0].corrupts[0].size)
+	assert.Equal(t, 1, len(result.files[0].records))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Nuyoahch/tinykv"
+	"github.com/gofrs/flock"
+	"os"
+	"path/filepath"
+)
+
+// 和数据库使用同一个文件锁，避免检查时数据库正在写入
+const fileLockName = "flock"
+
+// 只读实例持有共享锁的文件，修复时需要等待只读实例退出
+const readerLockName = "flock-reader"
+
+func main() {
+	repair := flag.Bool("repair", false, "rewrite damaged files, keeping only the valid records")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: tinykv-check [--repair] <dir>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	damaged, err := run(flag.Arg(0), *repair)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "tinykv-check:", err)
+		os.Exit(2)
+	}
+	// 发现损坏并且没有修复时返回非 0，便于脚本中使用
+	if damaged && !*repair {
+		os.Exit(1)
+	}
+}
+
+// 检查数据目录，需要修复时重写损坏的文件，返回是否发现了损坏
+func run(dirPath string, repair bool) (bool, error) {
+	if stat, err := os.Stat(dirPath); err != nil {
+		return false, err
+	} else if !stat.IsDir() {
+		return false, fmt.Errorf("%s is not a directory", dirPath)
+	}
+
+	// 数据库正在使用时不能检查
+	fileLock := flock.New(filepath.Join(dirPath, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return false, err
+	}
+	if !hold {
+		return false, tinykv.ErrDatabaseIsUsing
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	// 修复会重写数据文件，只读实例打开时不能修复
+	if repair {
+		readerLock := flock.New(filepath.Join(dirPath, readerLockName))
+		hold, err := readerLock.TryLock()
+		if err != nil {
+			return false, err
+		}
+		if !hold {
+			return false, tinykv.ErrDatabaseIsUsing
+		}
+		defer func() {
+			_ = readerLock.Unlock()
+		}()
+	}
+
+	result, err := check(dirPath)
+	if err != nil {
+		return false, err
+	}
+	result.print(os.Stdout)
+
+	damaged := result.damaged()
+	if damaged && repair {
+		if err := result.repair(os.Stdout); err != nil {
+			return true, err
+		}
+	}
+	return damaged, nil
+}
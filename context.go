@@ -0,0 +1,121 @@
+package tinykv
+
+import (
+	"context"
+	"github.com/Nuyoahch/tinykv/index"
+	"github.com/Nuyoahch/tinykv/utils"
+	"os"
+	"time"
+)
+
+// 支持 context 的接口
+// 等待锁的过程中以及处理每条记录之前检查 ctx，取消或者超时之后返回 ctx.Err()
+
+const (
+	minLockWait = 10 * time.Microsecond // 获取锁失败之后第一次等待的时间
+	maxLockWait = time.Millisecond      // 获取锁失败之后最长的等待时间
+)
+
+// PutContext 写入 Key/Value 数据，等待锁的过程中可以被 ctx 取消
+// 开启 SyncWrites 时和 Put 一样组提交，只在加入提交队列之前检查 ctx
+func (db *DB) PutContext(ctx context.Context, key []byte, value []byte) error {
+	return db.put(ctx, key, value, 0)
+}
+
+// GetContext 根据 Key 读取数据，等待锁的过程中可以被 ctx 取消
+func (db *DB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if err := db.rlockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.RUnlock()
+	return db.get(key)
+}
+
+// FoldContext 获取所有的数据，并执行用户指定的操作，函数返回 false 时终止遍历
+// 每读取一条记录之前检查 ctx，取消之后停止遍历并返回 ctx.Err()
+func (db *DB) FoldContext(ctx context.Context, fn func(key []byte, value []byte) bool) error {
+	if err := db.rlockContext(ctx); err != nil {
+		return err
+	}
+	defer db.mu.RUnlock()
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	now := time.Now().UnixNano()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logRecordPos := iterator.Value()
+		// 跳过已经过期的 key
+		if logRecordPos.IsExpired(now) {
+			continue
+		}
+		value, err := db.getValueByPosition(logRecordPos)
+		if err != nil {
+			return err
+		}
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// BackupContext 备份数据库，将数据文件拷贝到新的目录中
+// 拷贝每个文件之前检查 ctx，取消之后如果目录是本次备份创建的则将其删除
+func (db *DB) BackupContext(ctx context.Context, dir string) error {
+	if err := db.rlockContext(ctx); err != nil {
+		return err
+	}
+	defer db.mu.RUnlock()
+
+	_, statErr := os.Stat(dir)
+	// 混合索引溢出的文件打开时会删除，不需要备份
+	err := utils.CopyDirContext(ctx, db.options.DirPath, dir, []string{fileLockName, readerLockName, index.HybridIndexFileName})
+	if err != nil && os.IsNotExist(statErr) {
+		_ = os.RemoveAll(dir)
+	}
+	return err
+}
+
+// 获取写锁，ctx 取消或者超时之前获取不到时返回 ctx.Err()
+func (db *DB) lockContext(ctx context.Context) error {
+	return lockContext(ctx, db.mu.TryLock, db.mu.Lock)
+}
+
+// 获取读锁，ctx 取消或者超时之前获取不到时返回 ctx.Err()
+func (db *DB) rlockContext(ctx context.Context) error {
+	return lockContext(ctx, db.mu.TryRLock, db.mu.RLock)
+}
+
+// sync.RWMutex 的等待不能被取消，通过 tryLock 轮询获取锁，每次失败之后等待的时间逐渐增加
+// ctx 永远不会被取消时直接调用 lock 阻塞等待
+func lockContext(ctx context.Context, tryLock func() bool, lock func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	}
+
+	wait := minLockWait
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for !tryLock() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if wait < maxLockWait {
+			wait *= 2
+		}
+		timer.Reset(wait)
+	}
+	return nil
+}
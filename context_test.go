@@ -0,0 +1,238 @@
+package tinykv
+
+import (
+	"context"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 前 n 次调用 Err 时返回 nil，之后表示已经被取消
+type countdownContext struct {
+	context.Context
+	n atomic.Int64
+}
+
+func newCountdownContext(n int64) *countdownContext {
+	ctx := &countdownContext{Context: context.Background()}
+	ctx.n.Store(n)
+	return ctx
+}
+
+func (ctx *countdownContext) Done() <-chan struct{} {
+	return make(chan struct{})
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDB_Context_Lock(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-context-lock")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.PutContext(context.Background(), utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	// 已经取消的 ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.PutContext(ctx, utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Equal(t, context.Canceled, err)
+	_, err = db.GetContext(ctx, utils.GetTestKey(1))
+	assert.Equal(t, context.Canceled, err)
+
+	// 等待锁的过程中超时
+	db.mu.Lock()
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = db.PutContext(ctx, utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = db.GetContext(ctx, utils.GetTestKey(1))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	err = db.FoldContext(ctx, func(key []byte, value []byte) bool { return true })
+	assert.Equal(t, context.DeadlineExceeded, err)
+	err = db.MergeContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 锁释放之后可以获取
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		db.mu.Unlock()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = db.PutContext(ctx, utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	_, err = db.GetContext(ctx, utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = db.GetContext(ctx, utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_PutContext_SyncWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-context-sync")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 和 Put 一样组提交，加入提交队列之前检查 ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.PutContext(ctx, utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Equal(t, context.Canceled, err)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := db.PutContext(context.Background(), utils.GetTestKey(i*50+j), utils.GetTestKey(j))
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, uint(400), db.Stat().KeyNum)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 只读实例不能写入
+	readOnlyOpts := opts
+	readOnlyOpts.ReadOnly = true
+	reader, err := Open(readOnlyOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	err = reader.PutContext(context.Background(), utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Equal(t, ErrReadOnly, err)
+}
+
+func TestDB_FoldContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-context-fold")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	// 遍历的过程中被取消
+	var count int
+	err = db.FoldContext(newCountdownContext(11), func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 10, count)
+
+	count = 0
+	err = db.FoldContext(context.Background(), func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+}
+
+func TestDB_MergeContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-context-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	stat := db.Stat()
+
+	// merge 的过程中被取消，不留下 merge 目录，数据保持不变
+	err = db.MergeContext(newCountdownContext(100))
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+	assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+	assert.False(t, db.isMerging)
+
+	// 之后可以正常 merge
+	err = db.MergeContext(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(500), db.Stat().KeyNum)
+	for i := 500; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_BackupContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-context-backup")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// 拷贝的过程中被取消，删除本次创建的备份目录
+	backupDir := filepath.Join(os.TempDir(), "bitcask-go-context-backup-dest")
+	defer os.RemoveAll(backupDir)
+	err = db.BackupContext(newCountdownContext(3), backupDir)
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(backupDir)
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.BackupContext(context.Background(), backupDir)
+	assert.Nil(t, err)
+	opts.DirPath = backupDir
+	backupDB, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(500), backupDB.Stat().KeyNum)
+	assert.Nil(t, backupDB.Close())
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"errors"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"sync"
+)
+
+// CompressionType 压缩算法类型，压缩过的记录会把类型写到 header 中
+type CompressionType = byte
+
+const (
+	// CompressionNone 不压缩
+	CompressionNone CompressionType = iota
+	// CompressionSnappy snappy 压缩，速度快
+	CompressionSnappy
+	// CompressionZstd zstd 压缩，压缩率高
+	CompressionZstd
+)
+
+var (
+	ErrUnknownCompression    = errors.New("unknown compression type")
+	ErrCompressionRegistered = errors.New("compression type is already registered")
+)
+
+// Compressor 压缩算法接口，可以通过 RegisterCompressor 接入自定义的实现
+// 实现需要保证并发安全
+type Compressor interface {
+	// Compress 压缩数据
+	Compress(src []byte) ([]byte, error)
+
+	// Decompress 解压数据
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	compressorsLock = new(sync.RWMutex)
+	compressors     = map[CompressionType]Compressor{
+		CompressionSnappy: snappyCompressor{},
+		CompressionZstd:   new(zstdCompressor),
+	}
+)
+
+// RegisterCompressor 注册自定义的压缩算法，类型会写入到数据文件中，注册之后不能再修改
+func RegisterCompressor(typ CompressionType, compressor Compressor) error {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	if typ == CompressionNone {
+		return ErrUnknownCompression
+	}
+	if _, ok := compressors[typ]; ok {
+		return ErrCompressionRegistered
+	}
+	compressors[typ] = compressor
+	return nil
+}
+
+// GetCompressor 获取对应类型的压缩算法
+func GetCompressor(typ CompressionType) (Compressor, bool) {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	compressor, ok := compressors[typ]
+	return compressor, ok
+}
+
+// CompressLogRecord 压缩 LogRecord 中的 value，返回新的 LogRecord
+// 压缩之后没有变小的 value 保持原样，不做压缩
+func CompressLogRecord(logRecord *LogRecord, typ CompressionType) (*LogRecord, error) {
+	if typ == CompressionNone || len(logRecord.Value) == 0 {
+		return logRecord, nil
+	}
+	compressor, ok := GetCompressor(typ)
+	if !ok {
+		return nil, ErrUnknownCompression
+	}
+	value, err := compressor.Compress(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) >= len(logRecord.Value) {
+		return logRecord, nil
+	}
+	compressed := *logRecord
+	compressed.Value = value
+	compressed.Compression = typ
+	return &compressed, nil
+}
+
+// 解压 value
+func decompressValue(value []byte, typ CompressionType) ([]byte, error) {
+	compressor, ok := GetCompressor(typ)
+	if !ok {
+		return nil, ErrUnknownCompression
+	}
+	return compressor.Decompress(value)
+}
+
+// snappy 压缩
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// zstd 压缩，编码器和解码器在第一次使用时初始化
+type zstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		if z.encoder, z.err = zstd.NewWriter(nil); z.err != nil {
+			return
+		}
+		z.decoder, z.err = zstd.NewReader(nil)
+	})
+	return z.err
+}
+
+func (z *zstdCompressor) Compress(src []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.encoder.EncodeAll(src, nil), nil
+}
+
+func (z *zstdCompressor) Decompress(src []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.decoder.DecodeAll(src, nil)
+}
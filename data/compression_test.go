@@ -0,0 +1,79 @@
+package data
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 简单的游程编码，只用于测试自定义压缩算法
+type runLengthCompressor struct{}
+
+func (runLengthCompressor) Compress(src []byte) ([]byte, error) {
+	var dst []byte
+	for i := 0; i < len(src); {
+		j := i
+		for j < len(src) && src[j] == src[i] && j-i < 255 {
+			j++
+		}
+		dst = append(dst, byte(j-i), src[i])
+		i = j
+	}
+	return dst, nil
+}
+
+func (runLengthCompressor) Decompress(src []byte) ([]byte, error) {
+	var dst []byte
+	for i := 0; i+1 < len(src); i += 2 {
+		dst = append(dst, bytes.Repeat(src[i+1:i+2], int(src[i]))...)
+	}
+	return dst, nil
+}
+
+func TestCompressLogRecord(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask-kv-go","tags":["a","b","c"]}`), 20)
+	for _, typ := range []CompressionType{CompressionSnappy, CompressionZstd} {
+		record := &LogRecord{Key: []byte("name"), Value: value, Expire: 100}
+		compressed, err := CompressLogRecord(record, typ)
+		assert.Nil(t, err)
+		assert.Equal(t, typ, compressed.Compression)
+		assert.True(t, len(compressed.Value) < len(value))
+		assert.Equal(t, record.Expire, compressed.Expire)
+		// 原来的记录保持不变
+		assert.Equal(t, value, record.Value)
+		assert.Equal(t, CompressionNone, record.Compression)
+
+		decompressed, err := decompressValue(compressed.Value, typ)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+	}
+
+	// 压缩之后没有变小的 value 保持原样
+	record := &LogRecord{Key: []byte("name"), Value: []byte("a")}
+	compressed, err := CompressLogRecord(record, CompressionSnappy)
+	assert.Nil(t, err)
+	assert.Equal(t, record, compressed)
+
+	// 没有注册的压缩算法
+	_, err = CompressLogRecord(record, 200)
+	assert.Equal(t, ErrUnknownCompression, err)
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	err := RegisterCompressor(CompressionNone, runLengthCompressor{})
+	assert.Equal(t, ErrUnknownCompression, err)
+	err = RegisterCompressor(CompressionSnappy, runLengthCompressor{})
+	assert.Equal(t, ErrCompressionRegistered, err)
+
+	var typ CompressionType = 100
+	err = RegisterCompressor(typ, runLengthCompressor{})
+	assert.Nil(t, err)
+	compressor, ok := GetCompressor(typ)
+	assert.True(t, ok)
+	assert.Equal(t, runLengthCompressor{}, compressor)
+
+	record := &LogRecord{Key: []byte("name"), Value: bytes.Repeat([]byte("a"), 100)}
+	compressed, err := CompressLogRecord(record, typ)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{100, 'a'}, compressed.Value)
+}
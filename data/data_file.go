@@ -6,6 +6,7 @@ import (
 	"github.com/Nuyoahch/tinykv/fio"
 	"hash/crc32"
 	"io"
+	"os"
 	"path/filepath"
 )
 
@@ -17,9 +18,11 @@ var (
 // 文件相关常量参数
 const (
 	DataFileNameSuffix    = ".data"
+	BlobFileNameSuffix    = ".blob"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	NamespaceFileName     = "namespaces"
 )
 
 // DataFile 数据文件
@@ -27,6 +30,10 @@ type DataFile struct {
 	FileId    uint32        // 文件id
 	WriteOff  int64         // 文件写到了哪个位置
 	IoManager fio.IOManager // io 读写管理
+	Cipher    *Cipher       // 加密记录使用，为空时不能读取加密过的记录，写入时也不加密
+	Header    FileHeader    // 文件头，旧格式的文件版本为 LegacyFileVersion
+	// HeaderSize 文件头的长度，第一条记录从这个位置开始，旧格式的文件没有文件头，为 0
+	HeaderSize int64
 }
 
 // OpenDataFile 打开新的数据文件
@@ -55,6 +62,22 @@ func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFile)
 }
 
+// OpenNamespaceFile 打开保存命名空间的文件
+func OpenNamespaceFile(fileName string) (*DataFile, error) {
+	return newDataFile(fileName, 0, fio.StandardFile)
+}
+
+// OpenBlobFile 打开 blob 文件，blob 文件和数据文件使用相同的记录格式
+func OpenBlobFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetBlobFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType)
+}
+
+// GetBlobFileName 获取 blob 文件名称
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
 // GetDataFileName 获取数据文件名称
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
@@ -62,25 +85,147 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 
 // 创建新的数据文件
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	// 只有新创建的文件需要写入文件头，已经存在的空文件不修改
+	_, statErr := os.Stat(fileName)
+	created := os.IsNotExist(statErr)
 	// 初始化 IOManager 管理器接口
 	ioManager, err := fio.NewIOManager(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
 	// 初始化数据文件
-	return &DataFile{
+	dataFile := &DataFile{
 		FileId:    fileId,
 		WriteOff:  0,
 		IoManager: ioManager,
-	}, nil
+	}
+	if err := dataFile.initHeader(fileName, ioType, created); err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	return dataFile, nil
+}
+
+// 新建的文件写入文件头，已经存在的文件读取并检查文件头
+// 已经存在的空文件和以 MMap 方式打开的空文件不写入，按照旧格式处理，只读取文件时不会修改文件
+func (df *DataFile) initHeader(fileName string, ioType fio.FileIOType, created bool) error {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if fileSize == 0 {
+		if !created || ioType != fio.StandardFile {
+			return nil
+		}
+		df.Header = FileHeader{Version: CurrentFileVersion}
+		if err := df.Write(encodeFileHeader(&df.Header)); err != nil {
+			return err
+		}
+		df.HeaderSize = FileHeaderSize
+		return nil
+	}
+
+	headerBytes := int64(FileHeaderSize)
+	if fileSize < headerBytes {
+		headerBytes = fileSize
+	}
+	buf, err := df.readNBytes(headerBytes, 0)
+	if err != nil {
+		return err
+	}
+	header, err := decodeFileHeader(buf)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, fileName)
+	}
+	// 没有文件头的旧格式文件
+	if header == nil {
+		return nil
+	}
+	if err := header.check(fileName); err != nil {
+		return err
+	}
+	df.Header = *header
+	df.HeaderSize = FileHeaderSize
+	df.WriteOff = FileHeaderSize
+	return nil
 }
 
 // ReadLogRecord 根据 offset 从数据文件中读取 LogRecord
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+	header, headerBuf, recordSize, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	headerSize := int64(len(headerBuf))
+	// 取出对应的 key 和 value 的长度
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+
+	// 定义 logRecord 结构体
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire, Blob: header.blob, Namespace: header.namespace}
+
+	// 开始读取用户实际存储的 key/value 数据
+	if keySize > 0 || valueSize > 0 {
+		// 就是用户实际存储的数据
+		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// 解出 key 和 value
+		logRecord.Key = kvBuf[:keySize]
+		logRecord.Value = kvBuf[keySize:]
+	}
+
+	// 校验数据的有效性
+	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:])
+	// 校验失败时仍然返回记录的长度，便于跳过损坏的记录
+	if crc != header.crc {
+		return nil, recordSize, ErrInvalidCRC
+	}
+
+	// 解密 key 和 value
+	if header.encrypted {
+		if df.Cipher == nil {
+			return nil, 0, ErrCipherRequired
+		}
+		additional := headerBuf[crc32.Size:]
+		if df.Header.Version < fullHeaderAuthFileVersion {
+			additional = legacyAdditionalData(header.recordType, header.keyId)
+		}
+		key, value, err := df.Cipher.decrypt(header.keyId, logRecord.Value, additional)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.Key, logRecord.Value = key, value
+	}
+
+	// 解压 value，对外返回的都是原始数据
+	if header.compression != CompressionNone {
+		value, err := decompressValue(logRecord.Value, header.compression)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.Value = value
+	}
+	// 有效信息，返回结果
+	return logRecord, recordSize, nil
+}
+
+// 读取 offset 处记录的 header，返回解码之后的 header、header 的原始数据以及整条记录的长度
+// 只检查记录是否完整，crc 需要读取 key 和 value 之后再校验
+func (df *DataFile) readLogRecordHeader(offset int64) (*logRecordHeader, []byte, int64, error) {
 	// 获取到当前文件大小
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, 0, err
+	}
+
+	// 已经到达文件末尾，超出文件末尾的位置说明记录所在的部分已经不存在
+	if offset >= fileSize {
+		if offset == fileSize {
+			return nil, nil, 0, io.EOF
+		}
+		return nil, nil, 0, io.ErrUnexpectedEOF
 	}
 
 	// 如果读取的最大 header 长度已经超过了文件的长度，这只需要读取到文件的末尾即可
@@ -93,48 +238,57 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 读取 Handler 信息
 	headerBuf, err := df.readNBytes(headerBytes, offset)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, 0, err
 	}
 
 	header, headerSize := decodeLogRecordHeader(headerBuf)
 	// 从数据文件当中读取完毕
 	if header == nil {
 		// 返回 EOF 错误
-		return nil, 0, io.EOF
+		if headerBytes == 0 {
+			return nil, nil, 0, io.EOF
+		}
+		// 文件末尾只有不完整的 header，说明写入时被中断
+		if headerBytes < maxLogRecordHeaderSize {
+			return nil, nil, 0, io.ErrUnexpectedEOF
+		}
+		// header 已经损坏，无法确定记录的长度
+		return nil, nil, 0, ErrInvalidCRC
 	}
 	// 判断后也读取文件模块
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
-		return nil, 0, io.EOF
+		return nil, nil, 0, io.EOF
 	}
 
 	// 取出对应的 key 和 value 的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	// 记录 recordSize 长度
 	var recordSize = headerSize + keySize + valueSize
+	// 记录超出了文件的末尾，说明写入时被中断
+	if offset+recordSize > fileSize {
+		return nil, nil, 0, io.ErrUnexpectedEOF
+	}
+	return header, headerBuf[:headerSize], recordSize, nil
+}
 
-	// 定义 logRecord 结构体
-	logRecord := &LogRecord{Type: header.recordType}
-
-	// 开始读取用户实际存储的 key/value 数据
-	if keySize > 0 || valueSize > 0 {
-		// 就是用户实际存储的数据
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+// KeyIds 返回文件中加密记录使用的所有密钥 id，只读取每条记录的 header
+func (df *DataFile) KeyIds() (map[uint32]struct{}, error) {
+	ids := make(map[uint32]struct{})
+	offset := df.HeaderSize
+	for {
+		header, _, size, err := df.readLogRecordHeader(offset)
 		if err != nil {
-			return nil, 0, err
+			// 文件末尾不完整的记录是写入时中断留下的
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ids, nil
+			}
+			return nil, err
 		}
-
-		// 解出 key 和 value
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
-	}
-
-	// 校验数据的有效性
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
-	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		if header.encrypted {
+			ids[header.keyId] = struct{}{}
+		}
+		offset += size
 	}
-	// 有效信息，返回结果
-	return logRecord, recordSize, nil
 }
 
 // Write 文件写入方法
@@ -147,14 +301,26 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
-// WriteHintRecord 写入 Hint 文件记录
-func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
+// WriteHintRecord 写入 Hint 文件记录，namespace 为 key 所属的命名空间
+func (df *DataFile) WriteHintRecord(key []byte, namespace uint32, pos *LogRecordPos) error {
 	hintRecord := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
+		Key:       key,
+		Value:     EncodeLogRecordPos(pos),
+		Namespace: namespace,
+	}
+	return df.WriteLogRecord(hintRecord)
+}
+
+// WriteLogRecord 编码并写入一条记录，设置了 Cipher 时先进行加密
+func (df *DataFile) WriteLogRecord(logRecord *LogRecord) error {
+	if df.Cipher != nil {
+		var err error
+		if logRecord, err = df.Cipher.EncryptLogRecord(logRecord); err != nil {
+			return err
+		}
 	}
 	// 进行编码
-	encRecord, _ := EncodeLogRecord(hintRecord)
+	encRecord, _ := EncodeLogRecord(logRecord)
 	// 写入文件记录
 	return df.Write(encRecord)
 }
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"errors"
 	"github.com/Nuyoahch/tinykv/fio"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"testing"
 )
@@ -74,7 +76,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res1)
 	assert.Nil(t, err)
 
-	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	readRec1, readSize1, err := dataFile.ReadLogRecord(dataFile.HeaderSize)
 	assert.Nil(t, err)
 	assert.Equal(t, rec1, readRec1)
 	assert.Equal(t, size1, readSize1)
@@ -89,7 +91,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res2)
 	assert.Nil(t, err)
 
-	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(dataFile.HeaderSize + size1)
 	assert.Nil(t, err)
 	assert.Equal(t, rec2, readRec2)
 	assert.Equal(t, size2, readSize2)
@@ -104,8 +106,159 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res3)
 	assert.Nil(t, err)
 
-	readRec3, readSize3, err := dataFile.ReadLogRecord(size1 + size2)
+	readRec3, readSize3, err := dataFile.ReadLogRecord(dataFile.HeaderSize + size1 + size2)
 	assert.Nil(t, err)
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_ReadLogRecord_Corrupted(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-corrupted")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFile)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask kv go"),
+	}
+	res, size := EncodeLogRecord(rec)
+	err = dataFile.Write(res)
+	assert.Nil(t, err)
+
+	// 记录不完整
+	err = dataFile.Write(res[:size-3])
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(dataFile.HeaderSize + size)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 只有不完整的 header
+	dataFile2, err := OpenDataFile(dir, 1, fio.StandardFile)
+	assert.Nil(t, err)
+	err = dataFile2.Write(res[:3])
+	assert.Nil(t, err)
+	_, _, err = dataFile2.ReadLogRecord(dataFile2.HeaderSize)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 位置超出了文件的末尾
+	_, _, err = dataFile2.ReadLogRecord(dataFile2.WriteOff)
+	assert.Equal(t, io.EOF, err)
+	_, _, err = dataFile2.ReadLogRecord(dataFile2.WriteOff + 100)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 校验失败时返回记录的长度
+	dataFile3, err := OpenDataFile(dir, 2, fio.StandardFile)
+	assert.Nil(t, err)
+	corrupted := append([]byte(nil), res...)
+	corrupted[size-1] ^= 0xff
+	err = dataFile3.Write(corrupted)
+	assert.Nil(t, err)
+	err = dataFile3.Write(res)
+	assert.Nil(t, err)
+	_, readSize, err := dataFile3.ReadLogRecord(dataFile3.HeaderSize)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Equal(t, size, readSize)
+	readRec, _, err := dataFile3.ReadLogRecord(dataFile3.HeaderSize + readSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+}
+
+func TestDataFile_ReadLogRecord_Compressed(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-compressed")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFile)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	// 压缩和没有压缩的记录混合在一个文件中
+	value := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	offset := dataFile.HeaderSize
+	for _, typ := range []CompressionType{CompressionNone, CompressionSnappy, CompressionZstd} {
+		rec, err := CompressLogRecord(&LogRecord{Key: []byte("name"), Value: value}, typ)
+		assert.Nil(t, err)
+		res, size := EncodeLogRecord(rec)
+		assert.True(t, typ == CompressionNone || size < int64(len(value)))
+		err = dataFile.Write(res)
+		assert.Nil(t, err)
+
+		readRec, readSize, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, &LogRecord{Key: []byte("name"), Value: value}, readRec)
+		assert.Equal(t, size, readSize)
+		offset += size
+	}
+}
+
+func TestDataFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-header")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 新建的文件写入文件头
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFile)
+	assert.Nil(t, err)
+	assert.Equal(t, FileHeader{Version: CurrentFileVersion}, dataFile.Header)
+	assert.Equal(t, int64(FileHeaderSize), dataFile.HeaderSize)
+	assert.Equal(t, int64(FileHeaderSize), dataFile.WriteOff)
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go")}
+	err = dataFile.WriteLogRecord(rec)
+	assert.Nil(t, err)
+	_ = dataFile.Close()
+
+	dataFile, err = OpenDataFile(dir, 0, fio.StandardFile)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFileVersion, dataFile.Header.Version)
+	readRec, _, err := dataFile.ReadLogRecord(dataFile.HeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	_ = dataFile.Close()
+
+	// 没有文件头的旧格式文件
+	res, _ := EncodeLogRecord(rec)
+	err = os.WriteFile(GetDataFileName(dir, 1), res, 0644)
+	assert.Nil(t, err)
+	dataFile, err = OpenDataFile(dir, 1, fio.StandardFile)
+	assert.Nil(t, err)
+	assert.Equal(t, LegacyFileVersion, dataFile.Header.Version)
+	assert.Equal(t, int64(0), dataFile.HeaderSize)
+	readRec, _, err = dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	_ = dataFile.Close()
+
+	// 已经存在的空文件不写入文件头
+	err = os.WriteFile(GetDataFileName(dir, 5), nil, 0644)
+	assert.Nil(t, err)
+	dataFile, err = OpenDataFile(dir, 5, fio.StandardFile)
+	assert.Nil(t, err)
+	assert.Equal(t, LegacyFileVersion, dataFile.Header.Version)
+	_ = dataFile.Close()
+	stat, err := os.Stat(GetDataFileName(dir, 5))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+
+	// 更新的版本写入的文件
+	err = os.WriteFile(GetDataFileName(dir, 2), encodeFileHeader(&FileHeader{Version: CurrentFileVersion + 1}), 0644)
+	assert.Nil(t, err)
+	_, err = OpenDataFile(dir, 2, fio.StandardFile)
+	assert.True(t, errors.Is(err, ErrUnsupportedFileVersion))
+
+	err = os.WriteFile(GetDataFileName(dir, 3), encodeFileHeader(&FileHeader{Version: CurrentFileVersion, Flags: 1 << 31}), 0644)
+	assert.Nil(t, err)
+	_, err = OpenDataFile(dir, 3, fio.StandardFile)
+	assert.True(t, errors.Is(err, ErrUnsupportedFileFeature))
+
+	// 文件头损坏
+	header := encodeFileHeader(&FileHeader{Version: CurrentFileVersion})
+	header[4] ^= 0xff
+	err = os.WriteFile(GetDataFileName(dir, 4), header, 0644)
+	assert.Nil(t, err)
+	_, err = OpenDataFile(dir, 4, fio.StandardFile)
+	assert.True(t, errors.Is(err, ErrInvalidFileHeader))
+}
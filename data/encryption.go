@@ -0,0 +1,172 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sync"
+)
+
+var (
+	ErrCipherRequired   = errors.New("the log record is encrypted, but no key provider is set")
+	ErrDecryptionFailed = errors.New("failed to decrypt log record, the key maybe wrong")
+	ErrKeyNotProvided   = errors.New("the encryption key is not provided")
+)
+
+// KeyProvider 提供加密使用的密钥，密钥的 id 会写入到每条记录中
+// 轮换密钥时 CurrentKey 返回新的密钥，旧的密钥仍然需要能够通过 Key 获取，直到 merge 使用新的密钥重新加密所有数据
+// 同一个 id 对应的密钥不能修改
+type KeyProvider interface {
+	// CurrentKey 返回当前用于加密的密钥及其 id
+	CurrentKey() (id uint32, key []byte, err error)
+
+	// Key 根据 id 返回解密使用的密钥
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider 使用固定的一组密钥
+type StaticKeyProvider struct {
+	Keys      map[uint32][]byte // 所有的密钥
+	CurrentId uint32            // 当前用于加密的密钥 id
+}
+
+// CurrentKey 返回当前用于加密的密钥
+func (kp *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := kp.Key(kp.CurrentId)
+	return kp.CurrentId, key, err
+}
+
+// Key 根据 id 返回密钥
+func (kp *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := kp.Keys[id]
+	if !ok {
+		return nil, ErrKeyNotProvided
+	}
+	return key, nil
+}
+
+// Cipher 使用 AES-GCM 对记录的 key 和 value 进行加密和认证
+//
+// 加密之后的记录 key 为空，value 为 nonce + 密文，明文为 key 的长度（变长）+ key + value，
+// 除 crc 之外的整个 header 作为附加数据参与认证，过期时间、命名空间等字段被篡改时无法解密
+type Cipher struct {
+	provider KeyProvider
+	mu       *sync.RWMutex
+	aeads    map[uint32]cipher.AEAD // 按照密钥 id 缓存
+}
+
+// NewCipher 初始化 Cipher，会检查当前的密钥是否可用
+func NewCipher(provider KeyProvider) (*Cipher, error) {
+	c := &Cipher{
+		provider: provider,
+		mu:       new(sync.RWMutex),
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+	id, key, err := provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.aead(id, key); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// EncryptLogRecord 使用当前的密钥加密 LogRecord，返回新的 LogRecord
+func (c *Cipher) EncryptLogRecord(logRecord *LogRecord) (*LogRecord, error) {
+	id, key, err := c.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, binary.MaxVarintLen32+len(logRecord.Key)+len(logRecord.Value))
+	n := binary.PutUvarint(plaintext, uint64(len(logRecord.Key)))
+	n += copy(plaintext[n:], logRecord.Key)
+	n += copy(plaintext[n:], logRecord.Value)
+
+	valueSize := aead.NonceSize() + n + aead.Overhead()
+	nonce := make([]byte, aead.NonceSize(), valueSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	encrypted := *logRecord
+	encrypted.Key = nil
+	encrypted.Encrypted = true
+	encrypted.KeyId = id
+	// 密文的长度是确定的，可以先编码出写入文件时的 header
+	header := encodeLogRecordHeader(&encrypted, 0, valueSize)
+	encrypted.Value = aead.Seal(nonce, nonce, plaintext[:n], header[crc32.Size:])
+	return &encrypted, nil
+}
+
+// CurrentKeyId 返回当前用于加密的密钥 id
+func (c *Cipher) CurrentKeyId() (uint32, error) {
+	id, _, err := c.provider.CurrentKey()
+	return id, err
+}
+
+// 解密记录，返回原始的 key 和 value，additional 为加密时参与认证的附加数据
+func (c *Cipher) decrypt(id uint32, ciphertext, additional []byte) ([]byte, []byte, error) {
+	aead, err := c.aead(id, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, nil, ErrDecryptionFailed
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, additional)
+	if err != nil {
+		return nil, nil, ErrDecryptionFailed
+	}
+	keySize, n := binary.Uvarint(plaintext)
+	if n <= 0 || uint64(len(plaintext)-n) < keySize {
+		return nil, nil, ErrDecryptionFailed
+	}
+	key := plaintext[n : n+int(keySize)]
+	return key, plaintext[n+int(keySize):], nil
+}
+
+// 获取密钥 id 对应的 AEAD，key 为空时从 KeyProvider 中获取
+func (c *Cipher) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	c.mu.RLock()
+	aead, ok := c.aeads[id]
+	c.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	if key == nil {
+		var err error
+		if key, err = c.provider.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.aeads[id] = aead
+	c.mu.Unlock()
+	return aead, nil
+}
+
+// FileVersion 3 之前的文件只有记录类型和密钥 id 作为附加数据参与认证
+func legacyAdditionalData(recordType LogRecordType, id uint32) []byte {
+	buf := make([]byte, 5)
+	buf[0] = recordType
+	binary.LittleEndian.PutUint32(buf[1:], id)
+	return buf
+}
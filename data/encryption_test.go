@@ -0,0 +1,145 @@
+package data
+
+import (
+	"bytes"
+	"github.com/Nuyoahch/tinykv/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func newTestKeyProvider() *StaticKeyProvider {
+	return &StaticKeyProvider{
+		Keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte("k"), 32),
+			2: bytes.Repeat([]byte("n"), 16),
+		},
+		CurrentId: 1,
+	}
+}
+
+func TestNewCipher(t *testing.T) {
+	c, err := NewCipher(newTestKeyProvider())
+	assert.Nil(t, err)
+	assert.NotNil(t, c)
+
+	// 密钥不存在
+	_, err = NewCipher(&StaticKeyProvider{})
+	assert.Equal(t, ErrKeyNotProvided, err)
+
+	// 密钥长度不正确
+	_, err = NewCipher(&StaticKeyProvider{Keys: map[uint32][]byte{0: []byte("short")}})
+	assert.NotNil(t, err)
+}
+
+func TestCipher_EncryptLogRecord(t *testing.T) {
+	provider := newTestKeyProvider()
+	c, err := NewCipher(provider)
+	assert.Nil(t, err)
+
+	record := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-kv-go"), Type: LogRecordDeleted, Expire: 100}
+	encrypted, err := c.EncryptLogRecord(record)
+	assert.Nil(t, err)
+	assert.True(t, encrypted.Encrypted)
+	assert.Equal(t, uint32(1), encrypted.KeyId)
+	assert.Nil(t, encrypted.Key)
+	assert.False(t, bytes.Contains(encrypted.Value, record.Value))
+	assert.Equal(t, record.Expire, encrypted.Expire)
+
+	header := encodeLogRecordHeader(encrypted, 0, len(encrypted.Value))
+	key, value, err := c.decrypt(1, encrypted.Value, header[4:])
+	assert.Nil(t, err)
+	assert.Equal(t, record.Key, key)
+	assert.Equal(t, record.Value, value)
+
+	// 除 crc 之外的整个 header 参与认证
+	for _, tampered := range []LogRecord{
+		{Type: LogRecordNormal, Expire: 100, Encrypted: true, KeyId: 1},
+		{Type: LogRecordDeleted, Expire: 200, Encrypted: true, KeyId: 1},
+		{Type: LogRecordDeleted, Encrypted: true, KeyId: 1},
+		{Type: LogRecordDeleted, Expire: 100, Encrypted: true, KeyId: 1, Namespace: 1},
+		{Type: LogRecordDeleted, Expire: 100, Encrypted: true, KeyId: 1, Blob: true},
+		{Type: LogRecordDeleted, Expire: 100, Encrypted: true, KeyId: 1, Compression: CompressionSnappy},
+	} {
+		header := encodeLogRecordHeader(&tampered, 0, len(encrypted.Value))
+		_, _, err = c.decrypt(1, encrypted.Value, header[4:])
+		assert.Equal(t, ErrDecryptionFailed, err)
+	}
+	_, _, err = c.decrypt(2, encrypted.Value, header[4:])
+	assert.Equal(t, ErrDecryptionFailed, err)
+
+	// 轮换密钥之后，新的记录使用新的密钥，旧的记录依然可以解密
+	provider.CurrentId = 2
+	encrypted2, err := c.EncryptLogRecord(record)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), encrypted2.KeyId)
+	_, value, err = c.decrypt(1, encrypted.Value, header[4:])
+	assert.Nil(t, err)
+	assert.Equal(t, record.Value, value)
+}
+
+func TestDataFile_ReadLogRecord_Encrypted(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-encrypted")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	c, err := NewCipher(newTestKeyProvider())
+	assert.Nil(t, err)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFile)
+	assert.Nil(t, err)
+	dataFile.Cipher = c
+
+	// 先压缩再加密
+	value := bytes.Repeat([]byte("bitcask-kv-go"), 100)
+	record, err := CompressLogRecord(&LogRecord{Key: []byte("name"), Value: value, Expire: 100}, CompressionSnappy)
+	assert.Nil(t, err)
+	err = dataFile.WriteLogRecord(record)
+	assert.Nil(t, err)
+	err = dataFile.WriteHintRecord([]byte("hint"), 0, &LogRecordPos{Fid: 1, Offset: 10, Size: 20})
+	assert.Nil(t, err)
+
+	readRec, size, err := dataFile.ReadLogRecord(dataFile.HeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, &LogRecord{Key: []byte("name"), Value: value, Expire: 100}, readRec)
+	readRec, _, err = dataFile.ReadLogRecord(dataFile.HeaderSize + size)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hint"), readRec.Key)
+	assert.Equal(t, &LogRecordPos{Fid: 1, Offset: 10, Size: 20}, DecodeLogRecordPos(readRec.Value))
+
+	// 没有设置密钥时无法读取
+	dataFile.Cipher = nil
+	_, _, err = dataFile.ReadLogRecord(dataFile.HeaderSize)
+	assert.Equal(t, ErrCipherRequired, err)
+	dataFile.Cipher = c
+
+	// 修改 header 中的过期时间并重新计算 crc，认证失败
+	encrypted, err := c.EncryptLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-kv-go"), Expire: 100})
+	assert.Nil(t, err)
+	encrypted.Expire = 0
+	res, _ := EncodeLogRecord(encrypted)
+	offset := dataFile.WriteOff
+	err = dataFile.Write(res)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(offset)
+	assert.Equal(t, ErrDecryptionFailed, err)
+
+	// 旧版本的文件只有记录类型和密钥 id 参与认证
+	dataFile2, err := OpenDataFile(dir, 1, fio.StandardFile)
+	assert.Nil(t, err)
+	dataFile2.Cipher = c
+	dataFile2.Header.Version = 2
+	encrypted, err = c.EncryptLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-kv-go")})
+	assert.Nil(t, err)
+	aead, err := c.aead(1, nil)
+	assert.Nil(t, err)
+	plaintext, err := aead.Open(nil, encrypted.Value[:aead.NonceSize()], encrypted.Value[aead.NonceSize():], encodeLogRecordHeader(encrypted, 0, len(encrypted.Value))[4:])
+	assert.Nil(t, err)
+	nonce := encrypted.Value[:aead.NonceSize()]
+	encrypted.Value = aead.Seal(append([]byte(nil), nonce...), nonce, plaintext, legacyAdditionalData(LogRecordNormal, 1))
+	res, _ = EncodeLogRecord(encrypted)
+	err = dataFile2.Write(res)
+	assert.Nil(t, err)
+	readRec, _, err = dataFile2.ReadLogRecord(dataFile2.HeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, &LogRecord{Key: []byte("name"), Value: []byte("bitcask-kv-go")}, readRec)
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var (
+	ErrInvalidFileHeader      = errors.New("invalid file header, the file maybe corrupted")
+	ErrUnsupportedFileVersion = errors.New("unsupported file format version")
+	ErrUnsupportedFileFeature = errors.New("unsupported file format feature")
+)
+
+const (
+	// FileHeaderSize 文件头的长度
+	// magic(4) + version(2) + reserved(2) + flags(4) + crc(4)
+	FileHeaderSize = 16
+
+	// LegacyFileVersion 没有文件头的旧格式文件，文件开头就是记录
+	LegacyFileVersion uint16 = 0
+	// CurrentFileVersion 当前写入的文件格式版本，修改 LogRecord 的编码格式时需要递增
+	// 1: 增加文件头
+	// 2: LogRecord 增加命名空间
+	// 3: 加密记录除 crc 之外的整个 header 作为附加数据参与认证
+	CurrentFileVersion uint16 = 3
+
+	// 从这个版本开始加密记录使用整个 header 作为附加数据
+	fullHeaderAuthFileVersion uint16 = 3
+
+	// 当前版本可以识别的特性标识，文件中包含其他标识时拒绝打开
+	supportedFileFlags uint32 = 0
+)
+
+// 文件头开头的魔数，用来区分新格式的文件和旧格式的文件
+var fileMagic = []byte{'T', 'K', 'V', 'F'}
+
+// FileHeader 数据文件、hint 索引文件等文件开头的文件头
+type FileHeader struct {
+	Version uint16 // 文件格式版本
+	Flags   uint32 // 文件使用的特性标识，不认识的标识说明文件由更新的版本写入
+}
+
+// 对文件头进行编码
+//
+//	+-------+---------+----------+-------+-------+
+//	| magic | version | reserved | flags |  crc  |
+//	+-------+---------+----------+-------+-------+
+//	   4        2          2         4       4
+func encodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, FileHeaderSize)
+	copy(buf[:4], fileMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], header.Version)
+	binary.LittleEndian.PutUint32(buf[8:12], header.Flags)
+	binary.LittleEndian.PutUint32(buf[12:], crc32.ChecksumIEEE(buf[:12]))
+	return buf
+}
+
+// 对文件头进行解码，文件不是以魔数开头时说明是旧格式的文件，返回 nil
+func decodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < len(fileMagic) || !bytes.Equal(buf[:len(fileMagic)], fileMagic) {
+		return nil, nil
+	}
+	if len(buf) < FileHeaderSize {
+		return nil, ErrInvalidFileHeader
+	}
+	if binary.LittleEndian.Uint32(buf[12:16]) != crc32.ChecksumIEEE(buf[:12]) {
+		return nil, ErrInvalidFileHeader
+	}
+	return &FileHeader{
+		Version: binary.LittleEndian.Uint16(buf[4:6]),
+		Flags:   binary.LittleEndian.Uint32(buf[8:12]),
+	}, nil
+}
+
+// 检查是否能够读取这个版本的文件
+func (header *FileHeader) check(fileName string) error {
+	if header.Version > CurrentFileVersion {
+		return fmt.Errorf("%w: %s has version %d, the newest supported version is %d",
+			ErrUnsupportedFileVersion, fileName, header.Version, CurrentFileVersion)
+	}
+	if unknown := header.Flags &^ supportedFileFlags; unknown != 0 {
+		return fmt.Errorf("%w: %s has unknown flags %#x", ErrUnsupportedFileFeature, fileName, unknown)
+	}
+	return nil
+}
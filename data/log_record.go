@@ -17,22 +17,58 @@ const (
 	LogRecordTxnFinished
 )
 
-// crc type keySize valueSize -> 4 + 1 + 5 + 5 = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// type 字节的高位用作标记位，低位保存实际的记录类型
+const (
+	// 记录携带了过期时间
+	logRecordExpireFlag byte = 1 << 7
+	// 记录的 value 经过了压缩
+	logRecordCompressFlag byte = 1 << 6
+	// 记录的 key 和 value 经过了加密
+	logRecordEncryptFlag byte = 1 << 5
+	// 记录的 value 是 blob 文件中的位置
+	logRecordBlobFlag byte = 1 << 4
+	// 记录属于默认命名空间之外的命名空间
+	logRecordNamespaceFlag byte = 1 << 3
+	// 取出实际记录类型的掩码
+	logRecordTypeMask byte = 0x07
+)
+
+// crc type keySize valueSize expire compression keyId namespace -> 4 + 1 + 5 + 5 + 10 + 1 + 5 + 5 = 36
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*4 + binary.MaxVarintLen64 + 6
 
 // LogRecord 写入到数据文件的记录
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间点，UnixNano 表示，0 表示永不过期
+
+	// value 的压缩算法，只在写入时使用，读取时会自动解压并重置为 CompressionNone
+	Compression CompressionType
+
+	// key 和 value 是否经过了加密，以及加密使用的密钥 id，只在写入时使用，读取时会自动解密并重置
+	Encrypted bool
+	KeyId     uint32
+
+	// value 中保存的是 blob 文件中的位置（EncodeLogRecordPos 编码），实际的 value 存放在 blob 文件中
+	Blob bool
+
+	// 记录所属的命名空间 id，0 表示默认的命名空间
+	Namespace uint32
 }
 
 // LogRecord 的头部信息
 type logRecordHeader struct {
-	crc        uint32        // crc 校验值
-	recordType LogRecordType // 标识 LogRecord 的类型
-	keySize    uint32        // key 的长度
-	valueSize  uint32        // value 的长度
+	crc         uint32          // crc 校验值
+	recordType  LogRecordType   // 标识 LogRecord 的类型
+	keySize     uint32          // key 的长度
+	valueSize   uint32          // value 的长度
+	expire      int64           // 过期时间点
+	compression CompressionType // value 的压缩算法
+	encrypted   bool            // 是否经过了加密
+	keyId       uint32          // 加密使用的密钥 id
+	blob        bool            // value 是否是 blob 文件中的位置
+	namespace   uint32          // 所属的命名空间 id
 }
 
 // LogRecordPos 数据内存索引，描述数据在磁盘位置
@@ -40,6 +76,9 @@ type LogRecordPos struct {
 	Fid    uint32 // 文件 id，表示数据存储的文件标识
 	Offset int64  // 位置偏移，表示数据存储文件的位置
 	Size   uint32 // 日志记录在磁盘上的大小
+	Expire int64  // 过期时间点，0 表示永不过期
+	// BlobSize value 存放在 blob 文件中时，blob 记录在磁盘上的大小，用于统计 blob 文件中可回收的空间
+	BlobSize uint32
 }
 
 // TransactionRecord 暂存的事务相关的数据
@@ -50,22 +89,17 @@ type TransactionRecord struct {
 
 // EncodeLogRecord 对 LogRecord 进行编码，返回字节数组及长度
 //
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//	+-------------+-------------+-------------+--------------+--------------+--------------+--------------+--------------+-------------+--------------+
+//	| crc 校验值  |  type 类型   |    key size |   value size |  expire 过期  | compression  |    key id    |  namespace   |      key    |      value   |
+//	+-------------+-------------+-------------+--------------+--------------+--------------+--------------+--------------+-------------+--------------+
+//	    4字节          1字节        变长（最大5）   变长（最大5）  变长（最大10，可选） 1字节（可选）   变长（最大5，可选）  变长（最大5，可选）   变长           变长
+//
+// 只有设置了过期时间的记录才会写入 expire 字段，压缩过的记录才会写入 compression 字段，加密过的记录才会写入 key id 字段，
+// 不属于默认命名空间的记录才会写入 namespace 字段，并在 type 字节上打上标记，value 存放在 blob 文件中的记录只打标记，
+// 没有这些信息的记录编码结果和之前保持一致
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
-	// 初始化一个 header 部分的字节数组
-	header := make([]byte, maxLogRecordHeaderSize)
-
-	// crc 在前面计算完毕之后进行操作，因为 crc 所占空间为 4 个字节，所以从第五个字节存储 Type
-	header[4] = logRecord.Type
-	var index = 5
-
-	// 5 字节之后，存储 key 和 value 的长度信息
-	// 使用变长类型，节省空间
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	header := encodeLogRecordHeader(logRecord, len(logRecord.Key), len(logRecord.Value))
+	var index = len(header)
 
 	// 编码后的长度
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
@@ -73,7 +107,7 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	encBytes := make([]byte, size)
 
 	// 将 header 部分的内容拷贝过来
-	copy(encBytes[:index], header[:index])
+	copy(encBytes[:index], header)
 	// 将 key 和 value 数据拷贝到字节数组中
 	copy(encBytes[index:], logRecord.Key)
 	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
@@ -87,13 +121,78 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	return encBytes, int64(size)
 }
 
-// EncodeLogRecordPos 对 LogRecordPos 进行编码
+// 编码 LogRecord 的 header，crc 部分留空，key 和 value 的长度由调用方指定
+// 加密时需要在生成密文之前得到 header 作为附加数据
+func encodeLogRecordHeader(logRecord *LogRecord, keySize, valueSize int) []byte {
+	// 初始化一个 header 部分的字节数组
+	header := make([]byte, maxLogRecordHeaderSize)
+
+	// crc 在前面计算完毕之后进行操作，因为 crc 所占空间为 4 个字节，所以从第五个字节存储 Type
+	header[4] = logRecord.Type
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordExpireFlag
+	}
+	if logRecord.Compression != CompressionNone {
+		header[4] |= logRecordCompressFlag
+	}
+	if logRecord.Encrypted {
+		header[4] |= logRecordEncryptFlag
+	}
+	if logRecord.Blob {
+		header[4] |= logRecordBlobFlag
+	}
+	if logRecord.Namespace != 0 {
+		header[4] |= logRecordNamespaceFlag
+	}
+	var index = 5
+
+	// 5 字节之后，存储 key 和 value 的长度信息
+	// 使用变长类型，节省空间
+	index += binary.PutVarint(header[index:], int64(keySize))
+	index += binary.PutVarint(header[index:], int64(valueSize))
+
+	// 存储过期时间
+	if logRecord.Expire > 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+
+	// 存储压缩算法
+	if logRecord.Compression != CompressionNone {
+		header[index] = logRecord.Compression
+		index++
+	}
+
+	// 存储加密使用的密钥 id
+	if logRecord.Encrypted {
+		index += binary.PutUvarint(header[index:], uint64(logRecord.KeyId))
+	}
+
+	// 存储所属的命名空间
+	if logRecord.Namespace != 0 {
+		index += binary.PutUvarint(header[index:], uint64(logRecord.Namespace))
+	}
+	return header[:index]
+}
+
+// IsExpired 判断记录在 now 时刻是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
+}
+
+// EncodeLogRecordPos 对 LogRecordPos 进行编码，过期时间只在设置时写入
+// blob 记录的大小写在过期时间之后，设置了 blob 记录的大小时总是写入过期时间
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*3+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire > 0 || pos.BlobSize > 0 {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
+	if pos.BlobSize > 0 {
+		index += binary.PutVarint(buf[index:], int64(pos.BlobSize))
+	}
 	return buf[:index]
 }
 
@@ -104,8 +203,18 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
-	return &LogRecordPos{Fid: uint32(fileId), Offset: offset, Size: uint32(size)}
+	size, n := binary.Varint(buf[index:])
+	index += n
+	// 兼容没有过期时间的旧编码
+	var expire, blobSize int64
+	if index < len(buf) {
+		expire, n = binary.Varint(buf[index:])
+		index += n
+	}
+	if index < len(buf) {
+		blobSize, _ = binary.Varint(buf[index:])
+	}
+	return &LogRecordPos{Fid: uint32(fileId), Offset: offset, Size: uint32(size), Expire: expire, BlobSize: uint32(blobSize)}
 }
 
 // 对字节数组中的 Handler 信息进行解码
@@ -117,22 +226,69 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	// 初始化参数
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] & logRecordTypeMask,
 	}
 
 	// 索引位置
 	var index = 5
 	// 取出实际的 key size
 	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.keySize = uint32(keySize)
 	// 下次读取的位置存储
 	index += n
 
 	// 取出实际的 value size
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// 取出过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.expire = expire
+		index += n
+	}
+
+	// 取出压缩算法
+	if buf[4]&logRecordCompressFlag != 0 {
+		if index >= len(buf) {
+			return nil, 0
+		}
+		header.compression = buf[index]
+		index++
+	}
+
+	// 取出加密使用的密钥 id
+	if buf[4]&logRecordEncryptFlag != 0 {
+		keyId, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.encrypted = true
+		header.keyId = uint32(keyId)
+		index += n
+	}
+	header.blob = buf[4]&logRecordBlobFlag != 0
+
+	// 取出所属的命名空间
+	if buf[4]&logRecordNamespaceFlag != 0 {
+		namespace, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.namespace = uint32(namespace)
+		index += n
+	}
+
 	return header, int64(index)
 }
 
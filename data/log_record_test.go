@@ -97,3 +97,102 @@ func TestGetLogRecordCrc(t *testing.T) {
 	crc3 := getLogRecordCRC(record3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(3745121815), crc3)
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	record := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-kv-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(record)
+	assert.NotNil(t, res)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.Equal(t, record.Expire, h.expire)
+	assert.Equal(t, n, size+int64(len(record.Key)+len(record.Value)))
+}
+
+func TestEncodeLogRecord_Compression(t *testing.T) {
+	record := &LogRecord{
+		Key:         []byte("name"),
+		Value:       []byte("compressed-value"),
+		Type:        LogRecordDeleted,
+		Expire:      1700000000000000000,
+		Compression: CompressionZstd,
+	}
+	res, n := EncodeLogRecord(record)
+	assert.NotNil(t, res)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordDeleted, h.recordType)
+	assert.Equal(t, record.Expire, h.expire)
+	assert.Equal(t, CompressionZstd, h.compression)
+	assert.Equal(t, n, size+int64(len(record.Key)+len(record.Value)))
+
+	// 不完整的 header
+	h, _ = decodeLogRecordHeader(res[:size-1])
+	assert.Nil(t, h)
+}
+
+func TestEncodeLogRecord_Blob(t *testing.T) {
+	record := &LogRecord{
+		Key:   []byte("name"),
+		Value: EncodeLogRecordPos(&LogRecordPos{Fid: 3, Offset: 16, Size: 1024}),
+		Type:  LogRecordNormal,
+		Blob:  true,
+	}
+	res, n := EncodeLogRecord(record)
+	assert.NotNil(t, res)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.True(t, h.blob)
+	assert.Equal(t, n, size+int64(len(record.Key)+len(record.Value)))
+
+	// 普通的记录没有标记
+	res, _ = EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("value")})
+	h, _ = decodeLogRecordHeader(res)
+	assert.False(t, h.blob)
+}
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos1 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	assert.Equal(t, pos1, DecodeLogRecordPos(EncodeLogRecordPos(pos1)))
+
+	pos2 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: 1700000000000000000}
+	assert.Equal(t, pos2, DecodeLogRecordPos(EncodeLogRecordPos(pos2)))
+	// blob 记录的大小
+	pos3 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, BlobSize: 4096}
+	assert.Equal(t, pos3, DecodeLogRecordPos(EncodeLogRecordPos(pos3)))
+
+	pos4 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: 1700000000000000000, BlobSize: 4096}
+	assert.Equal(t, pos4, DecodeLogRecordPos(EncodeLogRecordPos(pos4)))
+}
+
+func TestEncodeLogRecord_Namespace(t *testing.T) {
+	record := &LogRecord{
+		Key:       []byte("name"),
+		Value:     []byte("bitcask-go"),
+		Type:      LogRecordDeleted,
+		Namespace: 300,
+	}
+	res, n := EncodeLogRecord(record)
+	assert.NotNil(t, res)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordDeleted, h.recordType)
+	assert.Equal(t, uint32(300), h.namespace)
+	assert.Equal(t, n, size+int64(len(record.Key)+len(record.Value)))
+
+	// 默认命名空间的记录编码不变
+	res, _ = EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("value")})
+	h, _ = decodeLogRecordHeader(res)
+	assert.Equal(t, uint32(0), h.namespace)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+}
@@ -1,6 +1,8 @@
 package tinykv
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"github.com/Nuyoahch/tinykv/data"
 	"github.com/Nuyoahch/tinykv/fio"
@@ -14,38 +16,66 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 存放面向用户的操作接口
 
 const (
-	seqNoKey     = "seq.no"
-	fileLockName = "flock"
+	seqNoKey       = "seq.no"
+	fileLockName   = "flock"
+	readerLockName = "flock-reader"
 )
 
 // DB tiny kv 存储引擎实例
 type DB struct {
-	options         Options
-	mu              *sync.RWMutex             // 并发访问安全，读写锁
-	fileIds         []int                     // 文件 id 只能在加载索引的时候使用，不能在其他的地方更新和使用
-	activeFile      *data.DataFile            // 当前活跃文件，可以用于写入
-	olderFiles      map[uint32]*data.DataFile // 旧的数据文件，只能用于读
-	index           index.Indexer             // 内存索引
-	seqNo           uint64                    // 事务序列号，全局递增 atomic
-	isMerging       bool                      // 是否正在 merge
-	isInitial       bool                      // 是否是第一次初始化这个目录
-	seqFileExists   bool                      // seq 文件存在
-	fileLock        *flock.Flock              // 文件锁
-	bytesWrite      int                       // 当前累计写了多少个字节
-	reclaimableSize int64                     // 可回收的磁盘空间容量
+	options             Options
+	mu                  *sync.RWMutex                        // 并发访问安全，读写锁
+	fileIds             []int                                // 文件 id 只能在加载索引的时候使用，不能在其他的地方更新和使用
+	activeFile          *data.DataFile                       // 当前活跃文件，可以用于写入
+	olderFiles          map[uint32]*data.DataFile            // 旧的数据文件，只能用于读
+	index               index.Indexer                        // 内存索引
+	seqNo               uint64                               // 事务序列号，全局递增 atomic
+	isMerging           bool                                 // 是否正在 merge
+	isInitial           bool                                 // 是否是第一次初始化这个目录
+	seqFileExists       bool                                 // seq 文件存在
+	fileLock            *flock.Flock                         // 文件锁
+	bytesWrite          int                                  // 当前累计写了多少个字节
+	reclaimableSize     int64                                // 可回收的磁盘空间容量
+	fileRefs            map[*data.DataFile]int               // 数据文件被快照引用的次数
+	retiredFiles        map[*data.DataFile]struct{}          // 已经被替换掉，等待引用释放之后关闭的数据文件
+	mergeScheduler      *mergeScheduler                      // 后台自动 merge 调度
+	recoveryReport      RecoveryReport                       // 启动恢复时丢弃的数据
+	cipher              *data.Cipher                         // 加密数据使用，为空时不加密
+	activeBlobFile      *data.DataFile                       // 当前写入的 blob 文件
+	blobFiles           map[uint32]*data.DataFile            // 所有的 blob 文件，包括当前写入的 blob 文件
+	blobReclaimableSize int64                                // blob 文件中可回收的磁盘空间容量
+	committer           *groupCommitter                      // 需要持久化的并发写入的组提交
+	writeBuf            []byte                               // 组提交时暂存的数据，为 nil 时直接写入活跃文件
+	groupKeys           map[batchKey]struct{}                // 组提交中已经写入但还没有更新索引的 key
+	watchers            map[*Watcher]struct{}                // 变更的订阅者，数据库关闭之后为 nil
+	namespaces          map[uint32]*Namespace                // 所有的命名空间，不包括默认的命名空间
+	nextNamespaceId     uint32                               // 下一个创建的命名空间使用的 id
+	pendingTxns         map[uint64][]*data.TransactionRecord // 加载索引时还没有读取到完成标记的事务
+	mergeFileId         uint32                               // 加载时最近一次 merge 的 nonMergeFileId，只读模式下用于发现新的 merge
+	secondaryIndexes    map[string]*secondaryIndex           // 所有的二级索引
+	refreshedKeys       map[string]struct{}                  // 只读实例刷新时重放过的默认命名空间中的 key，刷新之后增量更新二级索引
+	fileTable           atomic.Pointer[fileTable]            // 不加锁读取时使用的文件表
+	fileSeq             atomic.Uint64                        // 替换数据文件期间为奇数，不加锁的读取根据它判断读取期间文件是否被替换
 }
 
 // Stat 文件元信息
 type Stat struct {
-	KeyNum          uint  // key 的数量
-	DataFileNum     uint  // 数据文件的个数
-	ReclaimableSize int64 // 磁盘可回收的空间，字节为单位
-	DiskSize        int64 // 所占磁盘空间的大小
+	KeyNum              uint  // key 的数量
+	DataFileNum         uint  // 数据文件的个数
+	ReclaimableSize     int64 // 数据文件中可回收的空间，字节为单位
+	DiskSize            int64 // 所占磁盘空间的大小
+	BlobFileNum         uint  // blob 文件的个数
+	BlobReclaimableSize int64 // blob 文件中可回收的空间，字节为单位
+	NamespaceNum        uint  // 命名空间的个数，不包括默认的命名空间
+	IndexMemorySize     int64 // 混合索引在内存中占用的字节数，为估算值，包括所有的命名空间，其他索引为 0
+	IndexDiskKeyNum     uint  // 混合索引溢出到磁盘上的 key 的数量，包括所有的命名空间
 }
 
 // Open 打开 tiny kv 存储引擎实例方法
@@ -58,69 +88,169 @@ func Open(options Options) (*DB, error) {
 	var isInitial = false
 	// 判断数据目录是否存在，如果不存在的话，就进行创建目录
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		// 只读方式打开时不创建目录
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.Mkdir(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
-	// 判断是否正在使用
+	// 判断是否正在使用，只读方式打开时使用单独的共享锁，不影响写入的实例和其他只读实例
 	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	tryLock := fileLock.TryLock
+	if options.ReadOnly {
+		fileLock = flock.New(filepath.Join(options.DirPath, readerLockName))
+		tryLock = fileLock.TryRLock
+	}
+	hold, err := tryLock()
 	if err != nil {
 		return nil, err
 	}
 	if !hold {
 		return nil, ErrDatabaseIsUsing
 	}
+	// 打开失败时释放文件锁，便于调整配置之后重新打开
+	var opened bool
+	defer func() {
+		if !opened {
+			_ = fileLock.Unlock()
+		}
+	}()
 
 	// 初始化 DB 实例结构体
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+		options:          options,
+		mu:               new(sync.RWMutex),
+		olderFiles:       make(map[uint32]*data.DataFile),
+		index:            index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites, options.IndexMemoryLimit),
+		isInitial:        isInitial,
+		fileLock:         fileLock,
+		fileRefs:         make(map[*data.DataFile]int),
+		retiredFiles:     make(map[*data.DataFile]struct{}),
+		blobFiles:        make(map[uint32]*data.DataFile),
+		committer:        newGroupCommitter(),
+		watchers:         make(map[*Watcher]struct{}),
+		namespaces:       make(map[uint32]*Namespace),
+		secondaryIndexes: make(map[string]*secondaryIndex),
+	}
+	// 打开失败时关闭已经打开的索引和数据文件
+	defer func() {
+		if !opened {
+			db.closeFilesAndIndex()
+		}
+	}()
+
+	// 初始化加密使用的 Cipher
+	if options.Encryption != nil {
+		if db.cipher, err = data.NewCipher(options.Encryption); err != nil {
+			return nil, err
+		}
 	}
 
-	// 加载 merge 文件
-	if err := db.loadMergeFiles(); err != nil {
+	// 加载数据文件、blob 文件和内存索引
+	if err := db.loadFilesAndIndex(); err != nil {
 		return nil, err
 	}
 
+	// 旧版本的活跃文件不再写入，之后的记录写入到新的文件中
+	if !db.options.ReadOnly {
+		if err := db.rotateOldVersionFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 构建配置中的二级索引
+	for name, extractor := range options.SecondaryIndexes {
+		if err := db.CreateIndex(name, extractor); err != nil {
+			return nil, err
+		}
+	}
+
+	// 发布文件表之后 Get 可以不加锁读取
+	db.publishFileTable()
+
+	// 开启后台自动 merge
+	if db.options.AutoMergeInterval > 0 && !db.options.ReadOnly {
+		db.mergeScheduler = newMergeScheduler(db)
+		db.mergeScheduler.start()
+	}
+
+	opened = true
+	return db, nil
+}
+
+// 加载命名空间、数据文件、blob 文件并构建内存索引，Open 和只读实例的 reload 共用
+// 只加载磁盘上的状态，不会创建订阅者、二级索引和后台 merge
+func (db *DB) loadFilesAndIndex() error {
+	// 加载命名空间，加载索引时需要根据命名空间区分记录
+	if err := db.loadNamespaces(); err != nil {
+		return err
+	}
+
+	// 加载 merge 文件，只读方式打开时由写入的实例处理
+	if !db.options.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return err
+		}
+	}
+
 	// 加载对应的数据文件
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
+	}
+
+	// 加载 blob 文件
+	if err := db.loadBlobFiles(); err != nil {
+		return err
 	}
 
 	// B+树不需要从文件中加载索引了
 	if db.options.IndexType != BPlusTree {
 		// 从 Hint 文件中加载索引
 		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+			return err
 		}
 
 		// 从数据文件中加载索引的方法
 		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+			return err
 		}
 
 		// 重置数据文件的 IO 类型
 		if db.options.MMapAtStartup {
 			if err := db.resetDataFileIoType(); err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
 
 	if db.options.IndexType == BPlusTree {
 		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return db, nil
+	// 根据索引计算 blob 文件中可回收的空间
+	db.loadBlobReclaimableSize()
+	return nil
+}
+
+// 关闭已经加载的索引和数据文件，加载失败时使用
+func (db *DB) closeFilesAndIndex() {
+	_ = db.index.Close()
+	_ = db.closeNamespaces()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+	for _, file := range db.blobFiles {
+		_ = file.Close()
+	}
 }
 
 // Close 关闭数据库
@@ -128,29 +258,43 @@ func (db *DB) Close() error {
 	defer func() {
 		_ = db.fileLock.Unlock()
 	}()
-	// 活跃文件为空
-	if db.activeFile == nil {
-		return nil
+	// 先停止后台 merge
+	if db.mergeScheduler != nil {
+		db.mergeScheduler.stop()
 	}
 	// 处理并发操作
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// 写当前事务序列号到文件中
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
+	// 数据文件即将关闭，之后的读取都在锁内进行
+	db.beginRemapFiles()
+
+	// 关闭所有的订阅者
+	db.closeWatchers()
+
+	// 关闭索引
+	if err := db.index.Close(); err != nil {
 		return err
 	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
+	if err := db.closeNamespaces(); err != nil {
 		return err
 	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
+	// 关闭 blob 文件
+	for _, file := range db.blobFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	// 活跃文件为空
+	if db.activeFile == nil {
+		return nil
+	}
+
+	// 写当前事务序列号到文件中，只读方式打开时不写入
+	if !db.options.ReadOnly {
+		if err := db.saveSeqNo(); err != nil {
+			return err
+		}
 	}
 
 	// 关闭当前活跃文件
@@ -164,9 +308,38 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+
+	// 关闭仍然被快照引用的已替换数据文件
+	for file := range db.retiredFiles {
+		_ = file.Close()
+	}
+	db.retiredFiles = make(map[*data.DataFile]struct{})
 	return nil
 }
 
+// 写当前事务序列号到文件中，只保留最新的序列号，并使用当前的密钥加密
+func (db *DB) saveSeqNo() error {
+	if err := os.Remove(filepath.Join(db.options.DirPath, data.SeqNoFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	seqNoFile.Cipher = db.cipher
+	defer func() {
+		_ = seqNoFile.Close()
+	}()
+	record := &data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
+	}
+	if err := seqNoFile.WriteLogRecord(record); err != nil {
+		return err
+	}
+	return seqNoFile.Sync()
+}
+
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
 	// 活跃文件为空
@@ -176,7 +349,7 @@ func (db *DB) Sync() error {
 	// 处理并发操作
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFiles()
 }
 
 // Stat 返回数据库的统计信息
@@ -192,48 +365,223 @@ func (db *DB) Stat() *Stat {
 	if err != nil {
 		panic(err)
 	}
-	return &Stat{
-		KeyNum:          uint(db.index.Size()),
-		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimableSize,
-		DiskSize:        dirSize,
+	stat := &Stat{
+		KeyNum:              uint(db.index.Size()),
+		DataFileNum:         dataFiles,
+		ReclaimableSize:     db.reclaimableSize,
+		DiskSize:            dirSize,
+		BlobFileNum:         uint(len(db.blobFiles)),
+		BlobReclaimableSize: db.blobReclaimableSize,
+		NamespaceNum:        uint(len(db.namespaces)),
+	}
+	// 统计混合索引的内存占用
+	indexes := []index.Indexer{db.index}
+	for _, ns := range db.namespaces {
+		indexes = append(indexes, ns.index)
 	}
+	for _, idx := range indexes {
+		if hybrid, ok := idx.(*index.HybridIndex); ok {
+			hybridStat := hybrid.Stat()
+			stat.IndexMemorySize += hybridStat.MemorySize
+			stat.IndexDiskKeyNum += uint(hybridStat.DiskKeyNum)
+		}
+	}
+	return stat
 }
 
 // Backup 备份数据库，将数据文件拷贝到新的目录中
 func (db *DB) Backup(dir string) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	return db.BackupContext(context.Background(), dir)
 }
 
 // Put 写入 Key/Value 相关数据，Key 不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(context.Background(), key, value, 0)
+}
+
+// PutWithTTL 写入带有过期时间的 Key/Value 数据，ttl 为 0 时表示永不过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+	return db.put(context.Background(), key, value, expireAt(ttl))
+}
+
+// Expire 为已存在的 key 重新设置过期时间，ttl 为 0 时表示清除过期时间
+func (db *DB) Expire(key []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	// 读取旧值和写入新记录需要在同一把锁内完成
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.get(key)
+	if err != nil {
+		return err
+	}
+	// 使用新的过期时间重新写入一条记录
+	return db.putRecord(key, value, expireAt(ttl))
+}
+
+// TTL 获取 key 剩余的存活时间，永不过期的 key 返回 -1
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(now) {
+		return 0, ErrKeyNotFound
+	}
+	if logRecordPos.Expire == 0 {
+		return -1, nil
+	}
+	return time.Duration(logRecordPos.Expire - now), nil
+}
+
+// CompareAndSwap 当 key 当前的值等于 expected 时写入新的 value，返回条件是否成立
+// 写入的新值保留 key 原来的过期时间
+// key 不存在时条件不成立，需要在 key 不存在时写入请使用 PutIfAbsent
+func (db *DB) CompareAndSwap(key []byte, expected []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return false, nil
+	}
+	current, err := db.getValueByPosition(logRecordPos)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	if err := db.putRecord(key, value, logRecordPos.Expire); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent 当 key 不存在（或者已经过期）时写入 value，返回是否写入成功
+// ttl 为 0 时表示永不过期
+func (db *DB) PutIfAbsent(key []byte, value []byte, ttl time.Duration) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if ttl < 0 {
+		return false, ErrInvalidTTL
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.get(key); err != ErrKeyNotFound {
+		return false, err
+	}
+	if err := db.putRecord(key, value, expireAt(ttl)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfEquals 当 key 当前的值等于 expected 时删除，返回条件是否成立
+func (db *DB) DeleteIfEquals(key []byte, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	if err := db.deleteRecord(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 写入数据，expire 为过期的时间点，等待锁的过程中可以被 ctx 取消
+func (db *DB) put(ctx context.Context, key []byte, value []byte, expire int64) error {
 	// 判断 key 是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
 
-	// 有效 -> 构造 LogRecord 结构体
-	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+	// 需要持久化时和其他并发的写入一起组提交，只写入和持久化一次
+	// 由 leader 统一获取锁，只能在加入提交队列之前检查 ctx
+	if db.options.SyncWrites {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return db.groupCommit(func() (func(), error) {
+			pos, err := db.appendLogRecord(newPutRecord(key, value, expire))
+			if err != nil {
+				return nil, err
+			}
+			return func() { db.updateIndexPut(key, value, pos) }, nil
+		})
 	}
 
+	// 写入数据文件和更新内存索引需要在同一把锁内完成，保证快照等读取看到一致的状态
+	if err := db.lockContext(ctx); err != nil {
+		return err
+	}
+	defer db.mu.Unlock()
+	return db.putRecord(key, value, expire)
+}
+
+// 追加写入一条数据并更新内存索引，在访问方法之前必须持有互斥锁
+func (db *DB) putRecord(key []byte, value []byte, expire int64) error {
 	// 拿到索引信息，追加写入到当前活跃数据文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(newPutRecord(key, value, expire))
 	if err != nil {
 		return err
 	}
 
 	// 更新内存索引
-	oldPos := db.index.Put(key, pos)
-	if oldPos != nil {
-		db.reclaimableSize += int64(oldPos.Size)
+	db.updateIndexPut(key, value, pos)
+	return nil
+}
+
+// 构造写入数据的 LogRecord
+func newPutRecord(key []byte, value []byte, expire int64) *data.LogRecord {
+	return &data.LogRecord{
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
+}
 
-	return nil
+// 写入数据之后更新内存索引并通知订阅者，在访问方法之前必须持有互斥锁
+func (db *DB) updateIndexPut(key []byte, value []byte, pos *data.LogRecordPos) {
+	if oldPos := db.index.Put(key, pos); oldPos != nil {
+		db.reclaim(oldPos)
+	}
+	db.putSecondaryIndexes(key, value)
+	db.notify(WatchPut, key, value, nonTransactionSeqNo)
 }
 
 // Delete 根据 key 删除对应的数据
@@ -242,25 +590,75 @@ func (db *DB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// 需要持久化时和其他并发的写入一起组提交
+	if db.options.SyncWrites {
+		db.mu.RLock()
+		pos := db.index.Get(key)
+		db.mu.RUnlock()
+		if pos == nil {
+			return nil
+		}
+		// 更新内存索引在组提交持久化之后进行，失败时通过 applyErr 返回
+		var applyErr error
+		if err := db.groupCommit(func() (func(), error) {
+			// 等待组提交期间 key 可能已经被删除
+			if db.index.Get(key) == nil {
+				return func() {}, nil
+			}
+			pos, err := db.appendLogRecord(newDeleteRecord(key))
+			if err != nil {
+				return nil, err
+			}
+			return func() {
+				// 同一组中之前的删除已经删除了 key，写入的删除记录可以直接回收
+				if db.index.Get(key) == nil {
+					db.reclaim(pos)
+					return
+				}
+				applyErr = db.updateIndexDelete(key, pos)
+			}, nil
+		}); err != nil {
+			return err
+		}
+		return applyErr
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
 	// 先检查 key 是否存在，如果不存在的话直接返回
 	if pos := db.index.Get(key); pos == nil {
 		return nil
 	}
+	return db.deleteRecord(key)
+}
 
-	// 构造 LogRecord，标识其是被删除的
-	logRecord := &data.LogRecord{
+// 追加写入一条删除记录并更新内存索引，在访问方法之前必须持有互斥锁
+func (db *DB) deleteRecord(key []byte) error {
+	// 写入到数据文件当中
+	pos, err := db.appendLogRecord(newDeleteRecord(key))
+	if err != nil {
+		return err
+	}
+	return db.updateIndexDelete(key, pos)
+}
+
+// 构造删除数据的 LogRecord，标识其是被删除的
+func newDeleteRecord(key []byte) *data.LogRecord {
+	return &data.LogRecord{
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
+}
 
-	// 写入到数据文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
-	if err != nil {
-		return nil
-	}
+// 写入删除记录之后更新内存索引并通知订阅者，在访问方法之前必须持有互斥锁
+func (db *DB) updateIndexDelete(key []byte, pos *data.LogRecordPos) error {
 	// 可回收的磁盘容量
-	db.reclaimableSize += int64(pos.Size)
+	db.reclaim(pos)
 
 	// 从内存索引中将其对应的 key 删除
 	oldPos, ok := db.index.Delete(key)
@@ -268,27 +666,85 @@ func (db *DB) Delete(key []byte) error {
 		return ErrIndexUpdateFailed
 	}
 	if oldPos != nil {
-		db.reclaimableSize += int64(oldPos.Size)
+		db.reclaim(oldPos)
+		db.deleteSecondaryIndexes(key)
+		db.notify(WatchDelete, key, nil, nonTransactionSeqNo)
 	}
 	return nil
 }
 
+// 统计记录失效之后可以回收的空间，value 存放在 blob 文件中时同时统计 blob 文件中的部分
+// 在访问方法之前必须持有互斥锁
+func (db *DB) reclaim(pos *data.LogRecordPos) {
+	db.reclaimableSize += int64(pos.Size)
+	db.blobReclaimableSize += int64(pos.BlobSize)
+}
+
 // Get 根据 Key 读取数据
+// 通常不需要获取数据库的锁，读取期间数据文件被 merge 替换时在锁内重新读取
 func (db *DB) Get(key []byte) ([]byte, error) {
+	// 判断 key 的有效性
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if value, ok, err := db.getWithoutLock(key); ok {
+		return value, err
+	}
+
 	// 处理并发操作
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.get(key)
+}
 
-	// 判断 key 的有效性
-	if len(key) == 0 {
-		return nil, ErrKeyIsEmpty
+// MultiGet 批量读取多个 key，返回的 value 和错误与 keys 一一对应，key 不存在时对应的错误为 ErrKeyNotFound
+// 只获取一次读锁，查找索引之后按照文件和偏移的顺序读取，尽量顺序访问磁盘
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 先从内存索引中取出所有 key 的位置
+	now := time.Now().UnixNano()
+	positions := make([]*data.LogRecordPos, len(keys))
+	order := make([]int, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		pos := db.index.Get(key)
+		if pos == nil || pos.IsExpired(now) {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		positions[i] = pos
+		order = append(order, i)
+	}
+
+	// 按照文件和偏移排序之后依次读取
+	sort.Slice(order, func(a, b int) bool {
+		pa, pb := positions[order[a]], positions[order[b]]
+		if pa.Fid != pb.Fid {
+			return pa.Fid < pb.Fid
+		}
+		return pa.Offset < pb.Offset
+	})
+	for _, i := range order {
+		values[i], errs[i] = db.getValueByPosition(positions[i])
 	}
+	return values, errs
+}
 
+// 根据 Key 读取数据，在访问方法之前必须持有互斥锁（读锁或写锁）
+func (db *DB) get(key []byte) ([]byte, error) {
 	// 从内存数据结构当中取出 key 对应的索引信息
 	logRecordPos := db.index.Get(key)
 
-	// 如果 key 从内存数据结构中没找到，就说明 key 是不存在的
-	if logRecordPos == nil {
+	// 如果 key 从内存数据结构中没找到，或者已经过期，就说明 key 是不存在的
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
@@ -296,16 +752,19 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
-// ListKeys 获取数据库中所有的 Key
+// ListKeys 获取数据库中所有的 Key，已经过期的 key 不会返回
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
+	defer iterator.Close()
 	// 设置 key 的存储空间
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	// 遍历所有 key
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	// 返回 key 存储数组
 	return keys
@@ -313,21 +772,7 @@ func (db *DB) ListKeys() [][]byte {
 
 // Fold 获取所有的数据，并执行用户指定的操作，函数返回 false 时终止遍历
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
-	// 并发操作
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	iterator := db.index.Iterator(false)
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
-		if err != nil {
-			return err
-		}
-		if !fn(iterator.Key(), value) {
-			break
-		}
-	}
-	return nil
+	return db.FoldContext(context.Background(), fn)
 }
 
 // 根据索引信息获取对应的 value
@@ -341,6 +786,11 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 		dataFile = db.olderFiles[logRecordPos.Fid]
 	}
 
+	return getValueFromDataFile(dataFile, db.blobFiles, logRecordPos)
+}
+
+// 从指定的数据文件中读取 value，value 存放在 blob 文件中时再从 blobFiles 中读取
+func getValueFromDataFile(dataFile *data.DataFile, blobFiles map[uint32]*data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
 	// 数据文件为空
 	if dataFile == nil {
 		// 返回错误标识
@@ -358,24 +808,24 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 		return nil, ErrKeyNotFound
 	}
 
+	if logRecord.Blob {
+		return getValueFromBlobFile(blobFiles, logRecord.Value)
+	}
+
 	// 实际返回数据
 	return logRecord.Value, nil
 }
 
-// appendLogRecordWithLock 在持有 db.mu 互斥锁的前提下追加一条日志记录，保证写入操作的并发安全。
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	// 并发安全
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
-}
-
-// 追加写入到活跃数据文件
+// 追加写入到活跃数据文件，在访问方法之前必须持有互斥锁
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	//// 并发写入操作，上面 appendLogRecordWithLock 进行处理
-	//db.mu.Lock()
-	//defer db.mu.Unlock()
-
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	// 组提交时索引在整组写入之后才更新，事务提交时根据写入过的 key 检测冲突
+	if db.groupKeys != nil {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		db.groupKeys[batchKey{namespace: logRecord.Namespace, key: string(realKey)}] = struct{}{}
+	}
 	// 判断当前活跃数据文件是否存在，因为数据库没有写入时无文件生成，如果为空则初始化数据文件
 	if db.activeFile == nil {
 		if err := db.setActiveDataFile(); err != nil {
@@ -383,11 +833,44 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
+	// 较大的 value 先写入 blob 文件，数据文件中只保存 blob 记录的位置
+	if db.options.BlobThreshold > 0 && logRecord.Type == data.LogRecordNormal &&
+		!logRecord.Blob && int64(len(logRecord.Value)) >= db.options.BlobThreshold {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		blobPos, err := db.writeBlob(realKey, logRecord.Value, logRecord.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		blobRecord := *logRecord
+		blobRecord.Value = data.EncodeLogRecordPos(blobPos)
+		blobRecord.Blob = true
+		logRecord = &blobRecord
+	}
+	var blobSize uint32
+	if logRecord.Blob {
+		blobSize = data.DecodeLogRecordPos(logRecord.Value).Size
+	}
+
+	// 按照配置压缩 value，然后再加密
+	logRecord, err := data.CompressLogRecord(logRecord, db.options.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if db.cipher != nil {
+		if logRecord, err = db.cipher.EncryptLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+	}
+
 	// 写入数据编码
 	encodeRecord, size := data.EncodeLogRecord(logRecord)
 
 	// 进行业务逻辑的判断，如果写入的数据已经到达了活跃文件的阈值，则关闭活跃文件，并打开新的文件
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
+	if db.activeFile.WriteOff+int64(len(db.writeBuf))+size > db.options.DataFileSize {
+		// 组提交暂存的数据属于当前的活跃文件，需要先写入
+		if err := db.flushWriteBuf(); err != nil {
+			return nil, err
+		}
 		// 现将当前活跃文件进行持久化，保证已有的数据持久化到磁盘当中
 		if err := db.activeFile.Sync(); err != nil {
 			return nil, err
@@ -402,9 +885,11 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	// 记录写入的 offset
-	writeOff := db.activeFile.WriteOff
-	if err := db.activeFile.Write(encodeRecord); err != nil {
+	// 记录写入的 offset，组提交时先暂存起来，由 leader 统一写入和持久化
+	writeOff := db.activeFile.WriteOff + int64(len(db.writeBuf))
+	if db.writeBuf != nil {
+		db.writeBuf = append(db.writeBuf, encodeRecord...)
+	} else if err := db.activeFile.Write(encodeRecord); err != nil {
 		return nil, err
 	}
 
@@ -414,8 +899,8 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		needSync = true
 	}
-	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+	if needSync && db.writeBuf == nil {
+		if err := db.syncActiveFiles(); err != nil {
 			return nil, err
 		}
 		if db.bytesWrite > 0 {
@@ -424,7 +909,13 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	// 构造内存索引信息，进行返回
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}
+	pos := &data.LogRecordPos{
+		Fid:      db.activeFile.FileId,
+		Offset:   writeOff,
+		Size:     uint32(size),
+		Expire:   logRecord.Expire,
+		BlobSize: blobSize,
+	}
 	return pos, nil
 }
 
@@ -442,18 +933,69 @@ func (db *DB) setActiveDataFile() error {
 	if err != nil {
 		return err
 	}
+	dataFile.Cipher = db.cipher
 
 	// 传递数据文件
 	db.activeFile = dataFile
+	// 写入记录之前发布，不加锁的读取才能找到这个文件
+	db.publishFileTable()
+	return nil
+}
+
+// 活跃文件是旧版本的格式时切换到新的文件，旧版本的文件中不能写入新格式的记录（例如命名空间中的记录）
+func (db *DB) rotateOldVersionFiles() error {
+	if db.activeFile != nil && db.activeFile.Header.Version < data.CurrentFileVersion {
+		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		if err := db.setActiveDataFile(); err != nil {
+			return err
+		}
+	}
+	if db.activeBlobFile != nil && db.activeBlobFile.Header.Version < data.CurrentFileVersion {
+		if err := db.setActiveBlobFile(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	fileIds, err := db.listDataFileIds()
 	if err != nil {
 		return err
 	}
+	// 进行赋值
+	db.fileIds = fileIds
+
+	// 遍历每一个文件 id，打开对应的数据文件
+	for i, fid := range fileIds {
+		var ioType = db.fileIOType()
+		if db.options.MMapAtStartup {
+			ioType = fio.MemoryMap
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		if err != nil {
+			return err
+		}
+		dataFile.Cipher = db.cipher
+		// 判断是否是活跃文件，最后一个 id 最大的，就是当前的活跃文件
+		if i == len(fileIds)-1 {
+			db.activeFile = dataFile
+		} else {
+			// 说明是旧的数据文件
+			db.olderFiles[uint32(fid)] = dataFile
+		}
+	}
+	return nil
+}
+
+// 查找数据目录中所有的数据文件 id，按照从小到大的顺序返回
+// 只读方式打开时，写入的实例刚刚创建还没有写入文件头的数据文件暂时不返回
+func (db *DB) listDataFileIds() ([]int, error) {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
 
 	// 数据文件标识
 	var fileIds []int
@@ -466,7 +1008,7 @@ func (db *DB) loadDataFiles() error {
 			fileId, err := strconv.Atoi(splitNames[0])
 			// 数据目录可能被损坏
 			if err != nil {
-				return ErrDataDirectoryCorrupted
+				return nil, ErrDataDirectoryCorrupted
 			}
 			// 追加写入
 			fileIds = append(fileIds, fileId)
@@ -475,28 +1017,25 @@ func (db *DB) loadDataFiles() error {
 
 	// 对文件 id 进行排序，需要从小到大一次加载
 	sort.Ints(fileIds)
-	// 进行赋值
-	db.fileIds = fileIds
 
-	// 遍历每一个文件 id，打开对应的数据文件
-	for i, fid := range fileIds {
-		var ioType = fio.StandardFile
-		if db.options.MMapAtStartup {
-			ioType = fio.MemoryMap
-		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+	if db.options.ReadOnly && len(fileIds) > 0 {
+		info, err := os.Stat(data.GetDataFileName(db.options.DirPath, uint32(fileIds[len(fileIds)-1])))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// 判断是否是活跃文件，最后一个 id 最大的，就是当前的活跃文件
-		if i == len(fileIds)-1 {
-			db.activeFile = dataFile
-		} else {
-			// 说明是旧的数据文件
-			db.olderFiles[uint32(fid)] = dataFile
+		if info.Size() < data.FileHeaderSize {
+			fileIds = fileIds[:len(fileIds)-1]
 		}
 	}
-	return nil
+	return fileIds, nil
+}
+
+// 打开数据文件使用的 IO 类型，只读方式打开时不会创建和修改文件
+func (db *DB) fileIOType() fio.FileIOType {
+	if db.options.ReadOnly {
+		return fio.ReadOnlyFile
+	}
+	return fio.StandardFile
 }
 
 // 从数据文件中加载索引，遍历文件中的所有记录，并更新到内存索引中
@@ -520,31 +1059,13 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	// updateIndex 根据日志类型更新内存索引：
-	//   - 普通记录：在索引中插入/更新 key -> logRecordPos
-	//   - 删除记录：从索引中删除该 key
-	// 如果更新失败（返回 false），说明索引实现出错，直接 panic。
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimableSize += int64(pos.Size)
-		} else {
-			oldPos = db.index.Put(key, pos)
-		}
-		if oldPos != nil {
-			db.reclaimableSize += int64(oldPos.Size)
-		}
+	// 加载时已经过期的记录和删除记录一样处理
+	now := time.Now().UnixNano()
+	db.pendingTxns = make(map[uint64][]*data.TransactionRecord)
+	if hasMerge {
+		db.mergeFileId = nonMergeFileId
 	}
 
-	// currentSeqNo 记录当前正在重放的事务序列号，默认是非事务（0）
-	var currentSeqNo uint64 = nonTransactionSeqNo
-
-	// transactionRecords 用于在启动时重放日志时，
-	// 按事务序列号分组暂存该事务内的所有记录，
-	// 等到确认事务完成（例如遇到 txn-fin 标记）后再一次性应用到索引。
-	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-
 	// 遍历所有的文件id，处理文件中的记录
 	for i, fid := range db.fileIds {
 		var fileId = uint32(fid)
@@ -560,8 +1081,8 @@ func (db *DB) loadIndexFromDataFiles() error {
 			dataFile = db.olderFiles[fileId]
 		}
 
-		// 偏移量
-		var offset int64 = 0
+		// 偏移量，从文件头之后开始读取
+		offset := dataFile.HeaderSize
 		// 循环处理文件当中的内容
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
@@ -571,39 +1092,20 @@ func (db *DB) loadIndexFromDataFiles() error {
 				if err == io.EOF {
 					break
 				}
-				return err
-			}
-
-			// 构造内存索引信息
-			pos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
-
-			// 解析 key，取出事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			// 非事务写入使用的序列号常量
-			if seqNo == nonTransactionSeqNo {
-				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, pos)
-			} else {
-				// 事务完成，可以更新到内存中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						// 更新内存索引
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					// 暂存数据
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    pos,
-					})
+				// 按照恢复模式处理损坏的记录，返回跳过的长度，为 0 时说明文件剩余的部分已经被丢弃
+				skipped, err := db.recoverCorruptRecord(dataFile, offset, size, err)
+				if err != nil {
+					return err
 				}
+				if skipped == 0 {
+					break
+				}
+				offset += skipped
+				continue
 			}
-			// 更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
-			}
+
+			// 更新内存索引
+			db.replayLogRecord(logRecord, fileId, offset, size, now)
 
 			// 递增 offset，下一次从新的位置开始读取
 			offset += size
@@ -615,11 +1117,78 @@ func (db *DB) loadIndexFromDataFiles() error {
 			db.activeFile.WriteOff = offset
 		}
 	}
-	// 更新当前最新的序列号
-	db.seqNo = currentSeqNo
+
+	// 没有完成标记的事务不会再完成，只读方式打开时写入的实例可能还在写入，需要保留
+	if !db.options.ReadOnly {
+		db.pendingTxns = nil
+	}
 	return nil
 }
 
+// 重放数据文件中的一条记录，更新内存索引和事务序列号，在访问方法之前必须持有互斥锁
+// 事务中的记录先暂存起来，读取到事务完成的标记之后再一起更新到内存索引中
+func (db *DB) replayLogRecord(logRecord *data.LogRecord, fid uint32, offset int64, size int64, now int64) {
+	// 构造内存索引信息
+	pos := &data.LogRecordPos{Fid: fid, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
+	if logRecord.Blob {
+		pos.BlobSize = data.DecodeLogRecordPos(logRecord.Value).Size
+	}
+
+	// 解析 key，取出事务序列号
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	// 非事务写入使用的序列号常量
+	if seqNo == nonTransactionSeqNo {
+		// 非事务操作，直接更新内存索引
+		db.replayIndex(realKey, logRecord.Namespace, logRecord.Type, pos, now)
+	} else {
+		// 事务完成，可以更新到内存中
+		if logRecord.Type == data.LogRecordTxnFinished {
+			for _, txnRecord := range db.pendingTxns[seqNo] {
+				// 更新内存索引
+				db.replayIndex(txnRecord.Record.Key, txnRecord.Record.Namespace, txnRecord.Record.Type, txnRecord.Pos, now)
+			}
+			delete(db.pendingTxns, seqNo)
+		} else {
+			// 暂存数据
+			logRecord.Key = realKey
+			db.pendingTxns[seqNo] = append(db.pendingTxns[seqNo], &data.TransactionRecord{
+				Record: logRecord,
+				Pos:    pos,
+			})
+		}
+	}
+	// 更新事务序列号
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+}
+
+// 根据日志类型更新内存索引：
+//   - 普通记录：在索引中插入/更新 key -> logRecordPos
+//   - 删除记录或已过期的记录：从索引中删除该 key
+//
+// 属于已经删除的命名空间的记录直接回收
+func (db *DB) replayIndex(key []byte, namespace uint32, typ data.LogRecordType, pos *data.LogRecordPos, now int64) {
+	idx := db.indexOf(namespace)
+	if idx == nil {
+		db.reclaim(pos)
+		return
+	}
+	if namespace == 0 && db.refreshedKeys != nil {
+		db.refreshedKeys[string(key)] = struct{}{}
+	}
+	var oldPos *data.LogRecordPos
+	if typ == data.LogRecordDeleted || pos.IsExpired(now) {
+		oldPos, _ = idx.Delete(key)
+		db.reclaim(pos)
+	} else {
+		oldPos = idx.Put(key, pos)
+	}
+	if oldPos != nil {
+		db.reclaim(oldPos)
+	}
+}
+
 // 加载事务序列号
 func (db *DB) loadSeqNo() error {
 	// 获取文件名
@@ -633,7 +1202,8 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
-	record, _, err := seqNoFile.ReadLogRecord(0)
+	seqNoFile.Cipher = db.cipher
+	record, _, err := seqNoFile.ReadLogRecord(seqNoFile.HeaderSize)
 	if err != nil {
 		return err
 	}
@@ -649,6 +1219,35 @@ func (db *DB) loadSeqNo() error {
 	return os.Remove(seqNoFileName)
 }
 
+// 根据 ttl 计算过期的时间点，ttl 为 0 表示永不过期
+func expireAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// 将索引中已经过期的 key 移除，并计入可回收的空间，在访问方法之前必须持有互斥锁
+func (db *DB) removeExpiredKeys() {
+	now := time.Now().UnixNano()
+	var expiredKeys [][]byte
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired(now) {
+			expiredKeys = append(expiredKeys, iterator.Key())
+		}
+	}
+	// 先关闭迭代器再修改索引，B+ 树的读事务不能和写事务同时存在
+	iterator.Close()
+
+	for _, key := range expiredKeys {
+		if oldPos, ok := db.index.Delete(key); ok && oldPos != nil {
+			db.reclaim(oldPos)
+			db.deleteSecondaryIndexes(key)
+		}
+	}
+}
+
 // 检查传入配置项的校验
 func checkOptions(options Options) error {
 	// 目录文件为空
@@ -663,6 +1262,52 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	// 自动 merge 的时间窗口
+	if options.AutoMergeInterval < 0 {
+		return errors.New("invalid auto merge interval, must not be negative")
+	}
+	if options.AutoMergeWindowStart < 0 || options.AutoMergeWindowStart >= 24*time.Hour ||
+		options.AutoMergeWindowEnd < 0 || options.AutoMergeWindowEnd >= 24*time.Hour {
+		return errors.New("invalid auto merge window, must between 0 and 24h")
+	}
+	// value 的压缩算法
+	if _, ok := data.GetCompressor(options.Compression); options.Compression != NoCompression && !ok {
+		return data.ErrUnknownCompression
+	}
+	// 启动恢复模式
+	if options.RecoveryMode < RecoveryStrict || options.RecoveryMode > RecoverySkipCorrupt {
+		return errors.New("invalid recovery mode")
+	}
+	// blob 文件
+	if options.BlobThreshold < 0 {
+		return errors.New("invalid blob threshold, must not be negative")
+	}
+	if options.BlobThreshold > 0 && options.BlobFileSize <= 0 {
+		return errors.New("blob file size must be greater than zero")
+	}
+	if options.BlobMergeRatio < 0 || options.BlobMergeRatio > 1 {
+		return errors.New("invalid blob merge ratio, must between 0 and 1")
+	}
+	// 订阅者的缓冲区，为 0 时使用默认的大小
+	if options.WatchBufferSize < 0 {
+		return errors.New("watch buffer size can not be negative")
+	}
+	if options.WatchOverflow > WatchCloseOnOverflow {
+		return errors.New("invalid watch overflow policy")
+	}
+	// B+ 树索引文件需要写入，不能和写入的实例共享
+	if options.ReadOnly && options.IndexType == BPlusTree {
+		return errors.New("read-only mode does not support the b+ tree index")
+	}
+	// 混合索引溢出的文件也在数据目录中
+	if options.IndexType == Hybrid {
+		if options.ReadOnly {
+			return errors.New("read-only mode does not support the hybrid index")
+		}
+		if options.IndexMemoryLimit <= 0 {
+			return errors.New("index memory limit must be greater than zero")
+		}
+	}
 	return nil
 }
 
@@ -674,7 +1319,7 @@ func (db *DB) resetDataFileIoType() error {
 	if err := db.activeFile.IoManager.Close(); err != nil {
 		return err
 	}
-	ioManager, err := fio.NewFileIOManager(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId))
+	ioManager, err := fio.NewIOManager(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId), db.fileIOType())
 	if err != nil {
 		return err
 	}
@@ -683,7 +1328,7 @@ func (db *DB) resetDataFileIoType() error {
 		if err := file.IoManager.Close(); err != nil {
 			return err
 		}
-		ioManager, err := fio.NewFileIOManager(data.GetDataFileName(db.options.DirPath, file.FileId))
+		ioManager, err := fio.NewIOManager(data.GetDataFileName(db.options.DirPath, file.FileId), db.fileIOType())
 		if err != nil {
 			return err
 		}
@@ -1,10 +1,21 @@
 package tinykv
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Nuyoahch/tinykv/data"
 	"github.com/Nuyoahch/tinykv/utils"
 	"github.com/stretchr/testify/assert"
+	"hash/crc32"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // 测试完成之后销毁 DB 数据目录
@@ -30,6 +41,131 @@ func TestOpen(t *testing.T) {
 	assert.NotNil(t, db)
 }
 
+func TestOpen_FileFormat(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-format")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+
+	// 旧格式的数据文件没有文件头
+	var legacy []byte
+	for i := 0; i < 10; i++ {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeq(utils.GetTestKey(i), nonTransactionSeqNo),
+			Value: utils.GetTestKey(i),
+		})
+		legacy = append(legacy, encRecord...)
+	}
+	err := os.WriteFile(data.GetDataFileName(dir, 0), legacy, 0644)
+	assert.Nil(t, err)
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	// 旧格式的文件不再写入，打开时切换到新的活跃文件
+	assert.Equal(t, data.LegacyFileVersion, db.olderFiles[0].Header.Version)
+	assert.Equal(t, uint32(1), db.activeFile.FileId)
+	assert.Equal(t, data.CurrentFileVersion, db.activeFile.Header.Version)
+	for i := 0; i < 10; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// 新建的数据文件使用当前的格式
+	for i := 10; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, data.CurrentFileVersion, db.activeFile.Header.Version)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	fid := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 更新的版本写入的数据文件无法打开
+	file, err := os.OpenFile(data.GetDataFileName(dir, fid), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	header := make([]byte, data.FileHeaderSize)
+	_, err = file.ReadAt(header, 0)
+	assert.Nil(t, err)
+	binary.LittleEndian.PutUint16(header[4:6], data.CurrentFileVersion+1)
+	binary.LittleEndian.PutUint32(header[12:], crc32.ChecksumIEEE(header[:12]))
+	_, err = file.WriteAt(header, 0)
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrUnsupportedFileVersion))
+}
+
+func TestOpen_OldFileVersion(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-old-file-version")
+	opts.DirPath = dir
+	opts.BlobThreshold = 128
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(256))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 将数据文件和 blob 文件的文件头改为没有命名空间的版本 1
+	fileNames := []string{data.GetDataFileName(dir, 0), data.GetBlobFileName(dir, 0)}
+	fileSizes := make([]int64, len(fileNames))
+	for i, fileName := range fileNames {
+		file, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+		assert.Nil(t, err)
+		header := make([]byte, data.FileHeaderSize)
+		_, err = file.ReadAt(header, 0)
+		assert.Nil(t, err)
+		binary.LittleEndian.PutUint16(header[4:6], 1)
+		binary.LittleEndian.PutUint32(header[12:], crc32.ChecksumIEEE(header[:12]))
+		_, err = file.WriteAt(header, 0)
+		assert.Nil(t, err)
+		stat, err := file.Stat()
+		assert.Nil(t, err)
+		fileSizes[i] = stat.Size()
+		_ = file.Close()
+	}
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	ns, err := db.CreateNamespace("ns")
+	assert.Nil(t, err)
+	err = ns.Put([]byte("key"), utils.RandomValue(256))
+	assert.Nil(t, err)
+
+	// 命名空间的记录写入到新的文件中，旧版本的文件没有变化
+	assert.Equal(t, uint32(1), db.activeFile.FileId)
+	assert.Equal(t, data.CurrentFileVersion, db.activeFile.Header.Version)
+	assert.Equal(t, uint32(1), db.activeBlobFile.FileId)
+	assert.Equal(t, data.CurrentFileVersion, db.activeBlobFile.Header.Version)
+	for i, fileName := range fileNames {
+		stat, err := os.Stat(fileName)
+		assert.Nil(t, err)
+		assert.Equal(t, fileSizes[i], stat.Size())
+	}
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	_, err = ns.Get([]byte("key"))
+	assert.Nil(t, err)
+}
+
 func TestDB_Put(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-put")
@@ -368,3 +504,513 @@ func TestDB_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.ttl 为负数
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), -time.Second)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	// 2.未过期之前可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	// 3.过期之后 Get、ListKeys、Fold、Iterator 都不可见
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, utils.GetTestKey(2), keys[0])
+
+	var foldNum int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		foldNum++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, foldNum)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	defer iter.Close()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, utils.GetTestKey(2), iter.Key())
+
+	// 4.重启之后过期的数据依然不可见
+	err = db.PutWithTTL(utils.GetTestKey(3), utils.RandomValue(24), time.Hour)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val3, err := db2.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.NotNil(t, val3)
+	ttl, err := db2.TTL(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+}
+
+func TestDB_Expire(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-expire")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	err = db.Expire(utils.GetTestKey(1), time.Second)
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2.永不过期的 key
+	val := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(1), val)
+	assert.Nil(t, err)
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// 3.设置过期时间，value 保持不变
+	err = db.Expire(utils.GetTestKey(1), time.Minute)
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val, val1)
+
+	// 4.清除过期时间
+	err = db.Expire(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// 5.过期之后不能再设置
+	err = db.Expire(utils.GetTestKey(1), 50*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	err = db.Expire(utils.GetTestKey(1), time.Minute)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	ok, err := db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 2.值不相等
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("x"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3.值相等
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	// 4.并发递增计数器，不会丢失更新
+	err = db.Put(utils.GetTestKey(2), []byte(strconv.Itoa(0)))
+	assert.Nil(t, err)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					old, err := db.Get(utils.GetTestKey(2))
+					assert.Nil(t, err)
+					n, _ := strconv.Atoi(string(old))
+					ok, err := db.CompareAndSwap(utils.GetTestKey(2), old, []byte(strconv.Itoa(n+1)))
+					assert.Nil(t, err)
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), val)
+
+	// 5.写入新值之后保留原来的过期时间
+	err = db.PutWithTTL(utils.GetTestKey(3), []byte("a"), 100*time.Millisecond)
+	assert.Nil(t, err)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(3), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ttl, err := db.TTL(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 100*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(3), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("a"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("b"), 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// 过期的 key 视为不存在
+	err = db.PutWithTTL(utils.GetTestKey(2), []byte("a"), 50*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(2), []byte("b"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 写入时设置过期时间
+	ok, err = db.PutIfAbsent(utils.GetTestKey(3), []byte("a"), 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ttl, err := db.TTL(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	_, err = db.PutIfAbsent(nil, []byte("a"), 0)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	_, err = db.PutIfAbsent(utils.GetTestKey(4), []byte("a"), -time.Second)
+	assert.Equal(t, ErrInvalidTTL, err)
+}
+
+func TestDB_DeleteIfEquals(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-if-equals")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.Compression = SnappyCompression
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"name":"bitcask-kv-go","tags":["a","b","c"],"desc":"%s"}`,
+			i, strings.Repeat("tiny kv storage engine ", 20)))
+	}
+	var rawSize int
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), value(i))
+		assert.Nil(t, err)
+		rawSize += len(value(i))
+	}
+	assert.True(t, db.Stat().DiskSize < int64(rawSize)/2)
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, value(10), val)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 更换压缩算法之后，之前写入的数据依然可以读取
+	opts.Compression = ZstdCompression
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 1000; i < 2000; i++ {
+		err := db2.Put(utils.GetTestKey(i), value(i))
+		assert.Nil(t, err)
+	}
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	opts.Compression = NoCompression
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	for i := 2000; i < 2100; i++ {
+		err := db3.Put(utils.GetTestKey(i), value(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 2100; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value(i), val)
+	}
+
+	// 没有注册的压缩算法
+	opts.Compression = 200
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrUnknownCompression, err)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	tmpDir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	// B+ 树索引需要在新的目录中才能使用 WriteBatch
+	dir := filepath.Join(tmpDir, "db")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.Encryption = &data.StaticKeyProvider{
+		Keys:      map[uint32][]byte{1: []byte("0123456789abcdef0123456789abcdef")},
+		CurrentId: 1,
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put([]byte(fmt.Sprintf("user-%d", i)), []byte(fmt.Sprintf("secret-email-%d@example.com", i)))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put([]byte("user-batch"), []byte("secret-email-batch@example.com"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 数据文件和事务序列号文件中没有明文
+	for _, name := range []string{data.GetDataFileName(dir, 0), filepath.Join(dir, data.SeqNoFileName)} {
+		content, err := os.ReadFile(name)
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(content, []byte("secret-email")))
+		assert.False(t, bytes.Contains(content, []byte("user-")))
+	}
+
+	// 没有密钥或者密钥错误时无法打开
+	noKeyOpts := opts
+	noKeyOpts.Encryption = nil
+	_, err = Open(noKeyOpts)
+	assert.Equal(t, data.ErrCipherRequired, err)
+	wrongKeyOpts := opts
+	wrongKeyOpts.Encryption = &data.StaticKeyProvider{
+		Keys:      map[uint32][]byte{1: []byte("fedcba9876543210fedcba9876543210")},
+		CurrentId: 1,
+	}
+	_, err = Open(wrongKeyOpts)
+	assert.Equal(t, data.ErrDecryptionFailed, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db2.Get([]byte(fmt.Sprintf("user-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("secret-email-%d@example.com", i)), val)
+	}
+	val, err := db2.Get([]byte("user-batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-email-batch@example.com"), val)
+}
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(20), utils.RandomValue(10), time.Nanosecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond)
+
+	// key 的顺序和所在的文件无关
+	ids := []int{999, 0, 10, 500, 20, 1, 1000, 999}
+	keys := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = utils.GetTestKey(id)
+	}
+	keys = append(keys, nil)
+
+	vals, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(vals))
+	assert.Equal(t, len(keys), len(errs))
+	for i, id := range ids {
+		switch id {
+		case 10, 20, 1000:
+			assert.Equal(t, ErrKeyNotFound, errs[i])
+			assert.Nil(t, vals[i])
+		default:
+			assert.Nil(t, errs[i])
+			assert.Equal(t, values[id], vals[i])
+		}
+	}
+	assert.Equal(t, ErrKeyIsEmpty, errs[len(keys)-1])
+
+	vals, errs = db.MultiGet(nil)
+	assert.Equal(t, 0, len(vals))
+	assert.Equal(t, 0, len(errs))
+}
+
+func TestDB_HybridIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hybrid-index")
+	opts.DirPath = dir
+	opts.IndexType = Hybrid
+	opts.IndexMemoryLimit = 16 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	ns, err := db.CreateNamespace("ns")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, ns.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+
+	// 每个命名空间的索引单独限制内存
+	stat := db.Stat()
+	assert.Equal(t, uint(900), stat.KeyNum)
+	assert.True(t, stat.IndexMemorySize > 0 && stat.IndexMemorySize <= 2*opts.IndexMemoryLimit)
+	assert.True(t, stat.IndexDiskKeyNum > 0)
+
+	check := func(db *DB) {
+		for i := 0; i < 1000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			if i < 100 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		}
+		assert.Equal(t, 900, len(db.ListKeys()))
+		ns, err := db.Namespace("ns")
+		assert.Nil(t, err)
+		nsStat, err := ns.Stat()
+		assert.Nil(t, err)
+		assert.Equal(t, uint(1000), nsStat.KeyNum)
+	}
+	check(db)
+	assert.Nil(t, db.Merge())
+	check(db)
+
+	// 重新打开时根据数据文件重建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// 快照只复制内存中的 key，遍历的同时写入不会阻塞，关闭之后恢复溢出
+	snapshot := db.NewSnapshot()
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		assert.Nil(t, db.Put(iterator.Key(), []byte("updated")))
+	}
+	iterator.Close()
+	for i := 100; i < 1000; i++ {
+		val, err := snapshot.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotEqual(t, []byte("updated"), val)
+	}
+	snapshot.Release()
+	assert.True(t, db.Stat().IndexMemorySize <= 2*opts.IndexMemoryLimit)
+	val, err := db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("updated"), val)
+
+	// 混合索引不支持只读方式打开，内存限制必须大于 0
+	readOnlyOpts := opts
+	readOnlyOpts.ReadOnly = true
+	_, err = Open(readOnlyOpts)
+	assert.NotNil(t, err)
+	opts.IndexMemoryLimit = 0
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,85 @@
+package tinykv
+
+import (
+	"bytes"
+	"github.com/Nuyoahch/tinykv/data"
+	"sync"
+	"time"
+)
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，返回删除的 key 的数量
+// start 为空时从第一个 key 开始，end 为空时删除到最后一个 key
+// 所有的删除记录作为一个事务写入，要么全部生效，要么全部不生效
+func (db *DB) DeleteRange(start, end []byte) (int, error) {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return 0, nil
+	}
+	return db.deleteKeys(start, func(key []byte) bool {
+		return len(end) == 0 || bytes.Compare(key, end) < 0
+	})
+}
+
+// DeletePrefix 删除所有以 prefix 开头的 key，返回删除的 key 的数量
+// 所有的删除记录作为一个事务写入，要么全部生效，要么全部不生效
+func (db *DB) DeletePrefix(prefix []byte) (int, error) {
+	if len(prefix) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	return db.deleteKeys(prefix, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	})
+}
+
+// 从 seek 开始删除 inRange 返回 true 的连续的 key
+func (db *DB) deleteKeys(seek []byte, inRange func(key []byte) bool) (int, error) {
+	// 和 WriteBatch 一样依赖序列号
+	if db.options.IndexType == BPlusTree && !db.seqFileExists && !db.isInitial {
+		return 0, ErrWriteBatchCannotUse
+	}
+
+	var count int
+	// 在持有 db.mu 时查找需要删除的 key 并写入删除记录，保证删除的是同一时刻的数据
+	write := func() (func(), error) {
+		keys := db.rangeKeys(seek, inRange)
+		if len(keys) == 0 {
+			return func() {}, nil
+		}
+		wb := &WriteBatch{
+			options:       WriteBatchOptions{SyncWrites: db.options.SyncWrites},
+			mu:            new(sync.Mutex),
+			db:            db,
+			pendingWrites: make(map[batchKey]*data.LogRecord, len(keys)),
+		}
+		for _, key := range keys {
+			wb.pendingWrites[batchKey{key: string(key)}] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+		}
+		count = len(keys)
+		return wb.write()
+	}
+
+	if err := db.commitWrite(write); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// 从 seek 开始查找 inRange 返回 true 的连续的 key，跳过已经过期的 key，在访问方法之前必须持有互斥锁
+// 更新索引之前需要关闭迭代器，所以这里拷贝一份 key 返回
+func (db *DB) rangeKeys(seek []byte, inRange func(key []byte) bool) [][]byte {
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+
+	var keys [][]byte
+	now := time.Now().UnixNano()
+	for iterator.Seek(seek); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if !inRange(key) {
+			break
+		}
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, append([]byte(nil), key...))
+	}
+	return keys
+}
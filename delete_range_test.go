@@ -0,0 +1,97 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	for _, syncWrites := range []bool{false, true} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+		opts.DirPath = dir
+		opts.SyncWrites = syncWrites
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for i := 0; i < 100; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+			assert.Nil(t, err)
+		}
+
+		// 空的范围
+		n, err := db.DeleteRange(utils.GetTestKey(20), utils.GetTestKey(10))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		// bitcask-key-000000010 ~ bitcask-key-000000019
+		n, err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+		assert.Nil(t, err)
+		assert.Equal(t, 10, n)
+		_, err = db.Get(utils.GetTestKey(19))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get(utils.GetTestKey(20))
+		assert.Nil(t, err)
+
+		// end 为空时删除到最后一个 key
+		n, err = db.DeleteRange(utils.GetTestKey(90), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, n)
+		assert.Equal(t, 80, len(db.ListKeys()))
+
+		// 可回收的空间和重启之后重新统计的结果一致
+		reclaimable := db.Stat().ReclaimableSize
+		assert.True(t, reclaimable > 0)
+		err = db.Close()
+		assert.Nil(t, err)
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, reclaimable, db.Stat().ReclaimableSize)
+		assert.Equal(t, 80, len(db.ListKeys()))
+
+		n, err = db.DeleteRange(nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 80, n)
+		assert.Equal(t, 0, len(db.ListKeys()))
+		destroyDB(db)
+	}
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-prefix")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	_, err = db.DeletePrefix(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	for _, key := range []string{"tenant-a/1", "tenant-a/2", "tenant-a/3", "tenant-ab/1", "tenant-b/1"} {
+		err := db.Put([]byte(key), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+
+	n, err := db.DeletePrefix([]byte("tenant-a/"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	n, err = db.DeletePrefix([]byte("tenant-c/"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// 重启之后删除仍然生效
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, []byte("tenant-ab/1"), keys[0])
+	assert.Equal(t, []byte("tenant-b/1"), keys[1])
+}
@@ -8,6 +8,7 @@ var (
 	ErrIndexUpdateFailed      = errors.New("failed to update index")
 	ErrKeyNotFound            = errors.New("key not found in database")
 	ErrDataFileNotFound       = errors.New("data file is not found")
+	ErrBlobFileNotFound       = errors.New("blob file is not found")
 	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
 	ErrExceedMaxBatchNum      = errors.New("exceed the max write batch num")
 	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
@@ -15,4 +16,19 @@ var (
 	ErrDatabaseIsUsing        = errors.New("database directory is using by another process")
 	ErrMergeRatioUnreached    = errors.New("merge ratio is unreached")
 	ErrNoEnoughDiskForMerge   = errors.New("no enough disk space for merge")
+	ErrInvalidTTL             = errors.New("the ttl must not be negative")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys it read have been modified")
+	ErrTxnFinished            = errors.New("transaction has been committed or discarded")
+	ErrIteratorClosed         = errors.New("the iterator has been closed")
+	ErrIteratorKeysOnly       = errors.New("the iterator only iterates keys")
+	ErrWatcherOverflow        = errors.New("the watcher is closed because its buffer overflowed")
+	ErrDatabaseClosed         = errors.New("the database has been closed")
+	ErrNamespaceNameEmpty     = errors.New("the namespace name is empty")
+	ErrNamespaceExists        = errors.New("the namespace already exists")
+	ErrNamespaceNotFound      = errors.New("namespace not found in database")
+	ErrNamespaceDropped       = errors.New("the namespace has been dropped")
+	ErrReadOnly               = errors.New("the database is opened in read-only mode")
+	ErrSecondaryIndexExists   = errors.New("the secondary index already exists")
+	ErrSecondaryIndexNotFound = errors.New("secondary index not found in database")
 )
@@ -0,0 +1,88 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/index"
+	"time"
+)
+
+// 不获取数据库锁的读取
+// 数据文件、blob 文件和内存索引发生变化时，在锁内发布一份新的只读文件表，Get 通过原子操作获取当前的文件表，
+// 查找索引和读取数据文件都不需要获取数据库的锁，只有索引自身的锁
+// 追加写入时新的数据文件在写入记录之前发布，索引指向的文件一定在之后获取的文件表中
+// merge 等操作会删除数据文件、让相同的文件 id 指向不同的文件，这些操作期间 fileSeq 为奇数，
+// 读取前后 fileSeq 不一致时读取到的数据可能来自被替换的文件，改为在锁内重新读取
+
+// 只读的文件表，发布之后不再修改
+type fileTable struct {
+	index index.Indexer             // 默认命名空间的内存索引
+	files map[uint32]*data.DataFile // 所有的数据文件，包括活跃文件
+	blobs map[uint32]*data.DataFile // 所有的 blob 文件
+}
+
+// 根据当前的数据文件和索引发布新的文件表，在访问方法之前必须持有互斥锁
+func (db *DB) publishFileTable() {
+	table := &fileTable{
+		index: db.index,
+		files: make(map[uint32]*data.DataFile, len(db.olderFiles)+1),
+		blobs: make(map[uint32]*data.DataFile, len(db.blobFiles)),
+	}
+	for fid, file := range db.olderFiles {
+		table.files[fid] = file
+	}
+	if db.activeFile != nil {
+		table.files[db.activeFile.FileId] = db.activeFile
+	}
+	for fid, file := range db.blobFiles {
+		table.blobs[fid] = file
+	}
+	db.fileTable.Store(table)
+}
+
+// 开始替换或者删除数据文件，之后不加锁的读取都改为在锁内读取，在访问方法之前必须持有互斥锁
+func (db *DB) beginRemapFiles() {
+	db.fileSeq.Add(1)
+}
+
+// 替换完成，发布新的文件表之后恢复不加锁的读取，在访问方法之前必须持有互斥锁
+func (db *DB) endRemapFiles() {
+	db.publishFileTable()
+	db.fileSeq.Add(1)
+}
+
+// 不加锁读取时文件表发生变化的最大重试次数，超过之后在锁内读取
+const maxLockFreeRetries = 3
+
+// 不获取数据库的锁读取 key 对应的 value，读取期间数据文件被替换时返回 false，需要在锁内重新读取
+// 位置只在同一个文件表中查找，文件表发生变化或者索引指向之后才创建的文件时重新查找
+func (db *DB) getWithoutLock(key []byte) ([]byte, bool, error) {
+	for i := 0; i < maxLockFreeRetries; i++ {
+		seq := db.fileSeq.Load()
+		if seq%2 == 1 {
+			return nil, false, nil
+		}
+		table := db.fileTable.Load()
+		if table == nil {
+			return nil, false, nil
+		}
+
+		var value []byte
+		var err error
+		logRecordPos := table.index.Get(key)
+		if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+			err = ErrKeyNotFound
+		} else {
+			value, err = getValueFromDataFile(table.files[logRecordPos.Fid], table.blobs, logRecordPos)
+		}
+
+		if db.fileSeq.Load() != seq {
+			continue
+		}
+		// 索引指向获取文件表之后才创建的数据文件或者 blob 文件
+		if err == ErrDataFileNotFound || err == ErrBlobFileNotFound {
+			continue
+		}
+		return value, true, err
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,76 @@
+package tinykv
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDB_GetWithoutLock(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-get-without-lock")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BlobThreshold = 256
+	opts.BlobMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// value 以 key 开头，读取到其他 key 的数据时可以发现
+	value := func(i, version int) []byte {
+		size := 64
+		if version%2 == 1 {
+			size = 512
+		}
+		return append([]byte(fmt.Sprintf("%s-%d-", utils.GetTestKey(i), version)), utils.RandomValue(size)...)
+	}
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value(i, 0)))
+	}
+
+	// 并发读取的同时不断覆盖写入、merge 和 merge blob 文件
+	var stopped atomic.Bool
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; !stopped.Load(); n++ {
+				i := n % 200
+				val, err := db.Get(utils.GetTestKey(i))
+				if !assert.Nil(t, err) || !assert.True(t, bytes.HasPrefix(val, append(utils.GetTestKey(i), '-'))) {
+					return
+				}
+			}
+		}()
+	}
+	for version := 1; version <= 10; version++ {
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value(i, version)))
+		}
+		assert.Nil(t, db.Merge())
+		err := db.MergeBlobs()
+		assert.True(t, err == nil || err == ErrMergeRatioUnreached)
+	}
+	stopped.Store(true)
+	wg.Wait()
+
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(val, []byte(fmt.Sprintf("%s-10-", utils.GetTestKey(i)))))
+	}
+
+	// 关闭之后不再不加锁读取
+	assert.Nil(t, db.Close())
+	_, ok, _ := db.getWithoutLock(utils.GetTestKey(1))
+	assert.False(t, ok)
+}
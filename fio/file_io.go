@@ -20,6 +20,15 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// NewReadOnlyFileIOManager 以只读方式打开标准文件 IO，写入时返回错误
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_RDONLY, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd}, nil
+}
+
 // Read 从文件的给定位置读取对应的数据
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
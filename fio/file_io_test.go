@@ -98,3 +98,35 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestNewReadOnlyFileIOManager(t *testing.T) {
+	path := filepath.Join("/tmp", "readonly.data")
+	// 文件不存在时不会创建
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.True(t, os.IsNotExist(err))
+
+	fio, err := NewFileIOManager(path)
+	defer destroyFile(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	readOnly, err := NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	defer readOnly.Close()
+
+	// 另一个实例追加的数据可以读取到
+	_, err = fio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	b := make([]byte, 10)
+	n, err := readOnly.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, []byte("key-akey-b"), b)
+
+	_, err = readOnly.Write([]byte("key-c"))
+	assert.NotNil(t, err)
+	size, err := readOnly.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+}
@@ -10,6 +10,8 @@ type FileIOType = byte
 const (
 	StandardFile FileIOType = iota
 	MemoryMap
+	// ReadOnlyFile 以只读方式打开的标准文件 IO，文件不存在时不会创建
+	ReadOnlyFile
 )
 
 // IOManager 抽象 IO 管理接口，可接入不同的 IO 类型
@@ -37,6 +39,8 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case ReadOnlyFile:
+		return NewReadOnlyFileIOManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
@@ -5,6 +5,7 @@ go 1.24.10
 require (
 	github.com/gofrs/flock v0.13.0
 	github.com/google/btree v1.1.3
+	github.com/klauspost/compress v1.18.0
 	github.com/plar/go-adaptive-radix-tree v1.0.7
 	github.com/stretchr/testify v1.11.1
 	github.com/tidwall/redcon v1.6.2
@@ -0,0 +1,118 @@
+package tinykv
+
+import "sync"
+
+// 组提交
+// 需要持久化的并发写入先进入队列，队首的写入作为 leader，将当前队列中所有的写入合并为一次写入和一次持久化，
+// 完成之后统一更新内存索引并通知其他写入返回，其余的写入只需要等待
+type groupCommitter struct {
+	mu    *sync.Mutex
+	cond  *sync.Cond
+	queue []*commitRequest // 等待提交的写入，队首为当前的 leader
+}
+
+// 一次等待提交的写入
+type commitRequest struct {
+	// 持有 db.mu 时调用，写入数据记录，返回持久化之后更新内存索引的方法
+	write func() (func(), error)
+	err   error
+	done  bool
+}
+
+func newGroupCommitter() *groupCommitter {
+	gc := &groupCommitter{mu: new(sync.Mutex)}
+	gc.cond = sync.NewCond(gc.mu)
+	return gc
+}
+
+// 通过组提交写入数据，数据持久化并且更新内存索引之后才返回
+func (db *DB) groupCommit(write func() (func(), error)) error {
+	gc := db.committer
+	req := &commitRequest{write: write}
+
+	gc.mu.Lock()
+	gc.queue = append(gc.queue, req)
+	// 等待被其他 leader 提交，或者成为新的 leader
+	for !req.done && gc.queue[0] != req {
+		gc.cond.Wait()
+	}
+	if req.done {
+		gc.mu.Unlock()
+		return req.err
+	}
+	group := gc.queue
+	gc.mu.Unlock()
+
+	err := db.commitGroup(group)
+
+	gc.mu.Lock()
+	for _, r := range group {
+		if err != nil {
+			r.err = err
+		}
+		r.done = true
+	}
+	gc.queue = gc.queue[len(group):]
+	gc.cond.Broadcast()
+	gc.mu.Unlock()
+	return req.err
+}
+
+// 合并写入一组数据，只写入和持久化一次，成功之后依次更新内存索引
+func (db *DB) commitGroup(group []*commitRequest) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 写入的数据先暂存在 writeBuf 中
+	db.writeBuf = make([]byte, 0, 4096)
+	db.groupKeys = make(map[batchKey]struct{})
+	defer func() {
+		db.writeBuf = nil
+		db.groupKeys = nil
+	}()
+
+	applies := make([]func(), len(group))
+	for i, req := range group {
+		applies[i], req.err = req.write()
+	}
+
+	if err := db.flushWriteBuf(); err != nil {
+		return err
+	}
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+
+	for i, req := range group {
+		if req.err == nil && applies[i] != nil {
+			applies[i]()
+		}
+	}
+	return nil
+}
+
+// 将组提交暂存的数据写入到活跃文件中，在访问方法之前必须持有互斥锁
+func (db *DB) flushWriteBuf() error {
+	if len(db.writeBuf) == 0 {
+		return nil
+	}
+	if err := db.activeFile.Write(db.writeBuf); err != nil {
+		return err
+	}
+	db.writeBuf = db.writeBuf[:0]
+	return nil
+}
+
+// 持久化当前的活跃文件和活跃 blob 文件，在访问方法之前必须持有互斥锁
+func (db *DB) syncActiveFiles() error {
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile != nil {
+		return db.activeFile.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package tinykv
+
+import (
+	"bytes"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 并发写入、删除和提交批量写入，写入时会切换活跃文件
+	value := bytes.Repeat([]byte("group-commit"), 10)
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				key := utils.GetTestKey(g*100 + i)
+				assert.Nil(t, db.Put(key, value))
+				if i%2 == 0 {
+					assert.Nil(t, db.Delete(key))
+				}
+			}
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for i := 50; i < 60; i++ {
+				assert.Nil(t, wb.Put(utils.GetTestKey(g*100+i), value))
+			}
+			assert.Nil(t, wb.Commit())
+		}(g)
+	}
+	wg.Wait()
+
+	check := func(db *DB) {
+		assert.Equal(t, 32*20, len(db.ListKeys()))
+		for g := 0; g < 32; g++ {
+			for i := 0; i < 20; i++ {
+				_, err := db.Get(utils.GetTestKey(g*100 + i))
+				if i%2 == 0 {
+					assert.Equal(t, ErrKeyNotFound, err)
+				} else {
+					assert.Nil(t, err)
+				}
+			}
+			for i := 50; i < 60; i++ {
+				_, err := db.Get(utils.GetTestKey(g*100 + i))
+				assert.Nil(t, err)
+			}
+		}
+	}
+	check(db)
+	assert.True(t, db.Stat().DataFileNum > 1)
+
+	// 重启之后数据仍然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_GroupCommit_ConcurrentDelete(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-delete")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+
+	// 多个协程同时删除相同的 key，可能在同一组中提交，都不应该返回错误
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0, len(db.ListKeys()))
+	for i := 0; i < 100; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+}
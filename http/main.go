@@ -61,6 +61,37 @@ func handleGet(writer http.ResponseWriter, request *http.Request) {
 	_ = json.NewEncoder(writer).Encode(string(value))
 }
 
+func handleMultiGet(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 多个 key 通过重复的 key 参数传入，不存在的 key 不会出现在结果中
+	params := request.URL.Query()["key"]
+	keys := make([][]byte, len(params))
+	for i, key := range params {
+		keys[i] = []byte(key)
+	}
+
+	values, errs := db.MultiGet(keys)
+	result := make(map[string]string, len(keys))
+	for i, err := range errs {
+		if err == tinykv.ErrKeyNotFound || err == tinykv.ErrKeyIsEmpty {
+			continue
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			log.Printf("failed to get kv in db: %v\n", err)
+			return
+		}
+		result[params[i]] = string(values[i])
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(result)
+}
+
 func handleDelete(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodDelete {
 		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
@@ -110,6 +141,7 @@ func main() {
 	// 注册 http 的接口
 	http.HandleFunc("/bitcask/put", handlePut)
 	http.HandleFunc("/bitcask/get", handleGet)
+	http.HandleFunc("/bitcask/mget", handleMultiGet)
 	http.HandleFunc("/bitcask/delete", handleDelete)
 	http.HandleFunc("/bitcask/stat", handleStat)
 	http.HandleFunc("/bitcask/listkeys", handleListKeys)
@@ -0,0 +1,130 @@
+package index
+
+import (
+	"fmt"
+	"github.com/Nuyoahch/tinykv/data"
+	"math/rand"
+	"testing"
+)
+
+// 基准测试中预先写入的 key 数量
+const benchKeyNum = 100000
+
+var benchIndexers = []struct {
+	name string
+	new  func() Indexer
+}{
+	{"BTree", func() Indexer { return NewBTree() }},
+	{"ART", func() Indexer { return NewART() }},
+	{"Hash", func() Indexer { return NewHashIndex() }},
+}
+
+func benchKey(i int) []byte {
+	return []byte(fmt.Sprintf("tinykv-key-%09d", i))
+}
+
+// 新建索引并写入 benchKeyNum 条数据
+func newBenchIndexer(newIndexer func() Indexer, keys [][]byte) Indexer {
+	indexer := newIndexer()
+	for i, key := range keys {
+		indexer.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	return indexer
+}
+
+func benchKeys() [][]byte {
+	keys := make([][]byte, benchKeyNum)
+	for i := range keys {
+		keys[i] = benchKey(i)
+	}
+	return keys
+}
+
+func BenchmarkIndexer_Put(b *testing.B) {
+	keys := benchKeys()
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := bi.new()
+			pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				indexer.Put(keys[i%benchKeyNum], pos)
+			}
+		})
+	}
+}
+
+func BenchmarkIndexer_Get(b *testing.B) {
+	keys := benchKeys()
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := newBenchIndexer(bi.new, keys)
+			r := rand.New(rand.NewSource(1))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				indexer.Get(keys[r.Intn(benchKeyNum)])
+			}
+		})
+	}
+}
+
+// 多个 goroutine 并发读写，每 10 次操作中有 1 次写入
+func BenchmarkIndexer_GetPutParallel(b *testing.B) {
+	keys := benchKeys()
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := newBenchIndexer(bi.new, keys)
+			pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for i := 0; pb.Next(); i++ {
+					key := keys[r.Intn(benchKeyNum)]
+					if i%10 == 0 {
+						indexer.Put(key, pos)
+					} else {
+						indexer.Get(key)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkIndexer_Delete(b *testing.B) {
+	keys := benchKeys()
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := newBenchIndexer(bi.new, keys)
+			pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// 删除之后重新写入，保证每次都能删除到数据
+				key := keys[i%benchKeyNum]
+				indexer.Delete(key)
+				b.StopTimer()
+				indexer.Put(key, pos)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkIndexer_Iterator(b *testing.B) {
+	keys := benchKeys()
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := newBenchIndexer(bi.new, keys)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				iterator := indexer.Iterator(false)
+				iterator.Close()
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"github.com/Nuyoahch/tinykv/data"
 	"go.etcd.io/bbolt"
 	"path/filepath"
@@ -147,6 +148,14 @@ func (bi *bptreeIterator) Rewind() {
 
 func (bi *bptreeIterator) Seek(key []byte) {
 	bi.currKey, bi.currValue = bi.cursor.Seek(key)
+	// cursor 定位到第一个大于等于 key 的位置，反向遍历时需要的是最后一个小于等于 key 的位置
+	if bi.reverse {
+		if bi.currKey == nil {
+			bi.currKey, bi.currValue = bi.cursor.Last()
+		} else if bytes.Compare(bi.currKey, key) > 0 {
+			bi.currKey, bi.currValue = bi.cursor.Prev()
+		}
+	}
 }
 
 func (bi *bptreeIterator) Next() {
@@ -170,5 +179,6 @@ func (bi *bptreeIterator) Value() *data.LogRecordPos {
 }
 
 func (bi *bptreeIterator) Close() {
-	_ = bi.tx.Commit()
+	// 只读事务不能提交，需要回滚才会释放
+	_ = bi.tx.Rollback()
 }
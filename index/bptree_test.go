@@ -105,3 +105,33 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_Iterator_Seek(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-iter-seek")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	for _, key := range []string{"aa", "bb", "cc", "dd"} {
+		tree.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 12})
+	}
+
+	iter := tree.Iterator(false)
+	iter.Seek([]byte("bc"))
+	assert.Equal(t, []byte("cc"), iter.Key())
+	iter.Close()
+
+	// 反向遍历时定位到最后一个小于等于 key 的位置
+	iter = tree.Iterator(true)
+	iter.Seek([]byte("bc"))
+	assert.Equal(t, []byte("bb"), iter.Key())
+	iter.Seek([]byte("cc"))
+	assert.Equal(t, []byte("cc"), iter.Key())
+	iter.Seek([]byte("zz"))
+	assert.Equal(t, []byte("dd"), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
@@ -41,7 +41,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 // Get 根据 key 取出对应的索引位置信息
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -65,6 +67,8 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 
 // Size 索引中的数据量
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
@@ -79,6 +83,26 @@ func (bt *BTree) Iterator(reverse bool) Iterator {
 	return newBTreeIterator(bt.tree, reverse)
 }
 
+// AscendGreaterOrEqual 从第一个大于等于 key 的位置开始按顺序遍历，fn 返回 false 时终止遍历
+// 和 Iterator 不同，不需要复制所有的数据，遍历期间持有读锁，fn 中不能修改索引
+func (bt *BTree) AscendGreaterOrEqual(key []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	bt.tree.AscendGreaterOrEqual(&Item{key: key}, func(it btree.Item) bool {
+		return fn(it.(*Item).key, it.(*Item).pos)
+	})
+}
+
+// Clone 复制一份索引，底层使用写时复制，复制之后两份索引的修改互不影响
+func (bt *BTree) Clone() *BTree {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
 // Close 关闭操作
 func (bt *BTree) Close() error {
 	return nil
@@ -100,3 +100,47 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Clone(t *testing.T) {
+	bt1 := NewBTree()
+	bt1.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt1.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	bt2 := bt1.Clone()
+	assert.Equal(t, 2, bt2.Size())
+
+	// 修改原索引不影响复制的索引
+	bt1.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt1.Put([]byte("c"), &data.LogRecordPos{Fid: 2, Offset: 40})
+	bt1.Delete([]byte("b"))
+	assert.Equal(t, int64(10), bt2.Get([]byte("a")).Offset)
+	assert.NotNil(t, bt2.Get([]byte("b")))
+	assert.Nil(t, bt2.Get([]byte("c")))
+
+	// 修改复制的索引不影响原索引
+	bt2.Put([]byte("d"), &data.LogRecordPos{Fid: 3, Offset: 50})
+	assert.Nil(t, bt1.Get([]byte("d")))
+	assert.Equal(t, 2, bt1.Size())
+}
+
+func TestBTree_AscendGreaterOrEqual(t *testing.T) {
+	bt := NewBTree()
+	for _, key := range []string{"a", "c", "e", "g"} {
+		bt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+
+	var keys []string
+	bt.AscendGreaterOrEqual([]byte("b"), func(key []byte, pos *data.LogRecordPos) bool {
+		assert.NotNil(t, pos)
+		keys = append(keys, string(key))
+		return key[0] < 'e'
+	})
+	assert.Equal(t, []string{"c", "e"}, keys)
+
+	keys = nil
+	bt.AscendGreaterOrEqual([]byte("z"), func(key []byte, pos *data.LogRecordPos) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Nil(t, keys)
+}
@@ -0,0 +1,118 @@
+package index
+
+import (
+	"bytes"
+	"github.com/Nuyoahch/tinykv/data"
+	"slices"
+	"sync"
+)
+
+// 哈希索引的分片数量，必须是 2 的幂
+const hashShardNum = 256
+
+// HashIndex 分片的哈希索引
+// 只支持按 key 查找，适合以点查为主的场景，key 按照哈希值分布到不同的分片，每个分片单独加锁，减少并发读写时的锁竞争
+// 没有维护 key 的顺序，迭代时对所有的数据排序生成快照
+type HashIndex struct {
+	shards [hashShardNum]*hashShard
+}
+
+// 一个分片
+type hashShard struct {
+	items map[string]*data.LogRecordPos
+	lock  *sync.RWMutex
+}
+
+// NewHashIndex 新建哈希索引
+func NewHashIndex() *HashIndex {
+	h := &HashIndex{}
+	for i := range h.shards {
+		h.shards[i] = &hashShard{
+			items: make(map[string]*data.LogRecordPos),
+			lock:  new(sync.RWMutex),
+		}
+	}
+	return h
+}
+
+// Put 向索引中存储 key 对应的数据位置信息
+func (h *HashIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	shard := h.shard(key)
+	shard.lock.Lock()
+	oldPos := shard.items[string(key)]
+	shard.items[string(key)] = pos
+	shard.lock.Unlock()
+	return oldPos
+}
+
+// Get 根据 key 取出对应的索引位置信息
+func (h *HashIndex) Get(key []byte) *data.LogRecordPos {
+	shard := h.shard(key)
+	shard.lock.RLock()
+	pos := shard.items[string(key)]
+	shard.lock.RUnlock()
+	return pos
+}
+
+// Delete 根据 key 删除对应的索引位置信息
+func (h *HashIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	shard := h.shard(key)
+	shard.lock.Lock()
+	oldPos, ok := shard.items[string(key)]
+	if ok {
+		delete(shard.items, string(key))
+	}
+	shard.lock.Unlock()
+	return oldPos, ok
+}
+
+// Size 索引中的数据量
+func (h *HashIndex) Size() int {
+	var size int
+	for _, shard := range h.shards {
+		shard.lock.RLock()
+		size += len(shard.items)
+		shard.lock.RUnlock()
+	}
+	return size
+}
+
+// Iterator 索引迭代器，依次复制每个分片中的数据并排序，不同分片的数据不是同一时刻的
+func (h *HashIndex) Iterator(reverse bool) Iterator {
+	values := make([]*Item, 0, h.Size())
+	for _, shard := range h.shards {
+		shard.lock.RLock()
+		for key, pos := range shard.items {
+			values = append(values, &Item{key: []byte(key), pos: pos})
+		}
+		shard.lock.RUnlock()
+	}
+	slices.SortFunc(values, func(a, b *Item) int {
+		return bytes.Compare(a.key, b.key)
+	})
+	if reverse {
+		slices.Reverse(values)
+	}
+
+	// 排好序之后和 BTree 的迭代器相同
+	return &btreeIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+	}
+}
+
+// Close 关闭操作
+func (h *HashIndex) Close() error {
+	return nil
+}
+
+// 根据 key 的 FNV-1a 哈希值选择分片
+func (h *HashIndex) shard(key []byte) *hashShard {
+	hash := uint32(2166136261)
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return h.shards[hash&(hashShardNum-1)]
+}
@@ -0,0 +1,106 @@
+package index
+
+import (
+	"fmt"
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestHashIndex_Put(t *testing.T) {
+	h := NewHashIndex()
+	res1 := h.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res1)
+	h.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+
+	res2 := h.Put([]byte("key-1"), &data.LogRecordPos{Fid: 22, Offset: 33})
+	assert.Equal(t, uint32(1), res2.Fid)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Equal(t, 2, h.Size())
+}
+
+func TestHashIndex_Get(t *testing.T) {
+	h := NewHashIndex()
+	h.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	h.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+
+	pos := h.Get(nil)
+	assert.Equal(t, int64(100), pos.Offset)
+	pos = h.Get([]byte("key-1"))
+	assert.Equal(t, int64(12), pos.Offset)
+	assert.Nil(t, h.Get([]byte("not exist")))
+}
+
+func TestHashIndex_Delete(t *testing.T) {
+	h := NewHashIndex()
+	res1, ok1 := h.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
+
+	h.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := h.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, uint32(1), res2.Fid)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Nil(t, h.Get([]byte("key-1")))
+	assert.Equal(t, 0, h.Size())
+}
+
+func TestHashIndex_Iterator(t *testing.T) {
+	h := NewHashIndex()
+	for _, key := range []string{"ccde", "adse", "bbde", "bade"} {
+		h.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 12})
+	}
+
+	// 迭代器按照 key 排序
+	var keys []string
+	iter := h.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"adse", "bade", "bbde", "ccde"}, keys)
+
+	iter = h.Iterator(true)
+	iter.Seek([]byte("bb"))
+	assert.Equal(t, "bade", string(iter.Key()))
+	keys = nil
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"ccde", "bbde", "bade", "adse"}, keys)
+	iter.Close()
+
+	// 迭代器是创建时的快照
+	iter = h.Iterator(false)
+	h.Put([]byte("dddd"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	h.Delete([]byte("adse"))
+	iter.Rewind()
+	assert.Equal(t, "adse", string(iter.Key()))
+	var count int
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 4, count)
+}
+
+func TestHashIndex_Concurrent(t *testing.T) {
+	h := NewHashIndex()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", i, j))
+				h.Put(key, &data.LogRecordPos{Fid: uint32(i), Offset: int64(j)})
+				assert.Equal(t, int64(j), h.Get(key).Offset)
+				if j%2 == 0 {
+					h.Delete(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 8*500, h.Size())
+}
@@ -0,0 +1,581 @@
+package index
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/Nuyoahch/tinykv/data"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// HybridIndexFileName 混合索引溢出到磁盘的文件名称
+const HybridIndexFileName = "hybrid-index"
+
+// 内存中每个 key 除了 key 本身之外额外占用的字节数，包括位置信息、链表节点和 map 的开销，为估算值
+const hybridEntryOverhead = 128
+
+// HybridIndex 混合索引，最近访问的 key 保存在内存中，内存占用超过限制时将最久没有访问的 key 溢出到磁盘上的 B+ 树中
+// 磁盘上的 B+ 树只是内存的延伸，不需要持久化，写入时不会 fsync，打开和关闭时都会删除之前的文件，启动时和内存索引一样从数据文件中重建
+// 读取时不调整访问顺序，只设置访问标记，溢出时设置了标记的 key 移动到前面再保留一轮，读取之间不会互相阻塞
+// 读取到磁盘上的 key 时，内存还有剩余空间才重新加载到内存中，读取时不会溢出，磁盘上仍然保留一份，之后没有修改的话再次溢出时不需要重新写入
+//
+// 迭代器和快照打开期间持有 B+ 树的只读事务，bbolt 扩大文件时写入事务需要等待所有的只读事务结束，
+// 因此有迭代器或者快照时不写入磁盘：不溢出，删除磁盘上的 key 时在内存中保留删除标记，全部关闭之后再溢出
+type HybridIndex struct {
+	tree        *bbolt.DB
+	lock        *sync.RWMutex            // 读取时只设置访问标记，使用读写锁
+	entries     map[string]*list.Element // 内存中的 key，包括删除标记
+	lru         *list.List               // 按照访问时间排序，最近访问的在前面，读取的访问标记在溢出时处理
+	memorySize  int64                    // 内存中的 key 占用的字节数
+	memoryLimit int64                    // 内存占用的上限
+	diskKeyNum  int                      // 磁盘上的 key 的数量，包括同时在内存中的
+	size        int                      // 索引中的 key 的数量
+	readers     atomic.Int32             // 打开的迭代器和快照的数量，大于 0 时不写入磁盘
+}
+
+// 内存中的一个 key
+type hybridEntry struct {
+	key      string
+	pos      *data.LogRecordPos // 为 nil 时是删除标记，磁盘上的 key 已经被删除，溢出时从磁盘上删除
+	dirty    bool               // 内存中的位置比磁盘上的新，溢出时需要写入磁盘
+	onDisk   bool               // 磁盘上是否有这个 key
+	accessed atomic.Bool        // 上一次溢出之后是否读取过，持有读锁时设置
+}
+
+// HybridIndexStat 混合索引的内存使用情况
+type HybridIndexStat struct {
+	MemorySize   int64 // 内存中的 key 占用的字节数，为估算值
+	MemoryLimit  int64 // 内存占用的上限
+	MemoryKeyNum int   // 内存中的 key 的数量
+	DiskKeyNum   int   // 磁盘上的 key 的数量，包括同时在内存中的
+}
+
+// NewHybridIndex 新建混合索引，内存中的 key 最多占用 memoryLimit 字节
+func NewHybridIndex(dirPath string, memoryLimit int64) *HybridIndex {
+	fileName := filepath.Join(dirPath, HybridIndexFileName)
+	// 上一次没有正常关闭时留下的文件不再需要
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		panic("failed to remove hybrid index file at startup")
+	}
+	opts := *bbolt.DefaultOptions
+	opts.NoSync = true
+	opts.NoFreelistSync = true
+	tree, err := bbolt.Open(fileName, 0644, &opts)
+	if err != nil {
+		panic("failed to open hybrid index at startup")
+	}
+	if err := tree.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(indexBucketName)
+		return err
+	}); err != nil {
+		panic("failed to create hybrid index bucket at startup")
+	}
+	return &HybridIndex{
+		tree:        tree,
+		lock:        new(sync.RWMutex),
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		memoryLimit: memoryLimit,
+	}
+}
+
+// Put 向索引中存储 key 对应的数据位置信息
+func (h *HybridIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if elem, ok := h.entries[string(key)]; ok {
+		entry := elem.Value.(*hybridEntry)
+		oldPos := entry.pos
+		if oldPos == nil {
+			h.size++
+		}
+		entry.pos, entry.dirty = pos, true
+		entry.accessed.Store(false)
+		h.lru.MoveToFront(elem)
+		return oldPos
+	}
+
+	// 不在内存中时需要从磁盘上取出旧的位置
+	oldPos := h.diskGet(key)
+	if oldPos == nil {
+		h.size++
+	}
+	h.add(&hybridEntry{key: string(key), pos: pos, dirty: true, onDisk: oldPos != nil})
+	return oldPos
+}
+
+// Get 根据 key 取出对应的索引位置信息
+func (h *HybridIndex) Get(key []byte) *data.LogRecordPos {
+	h.lock.RLock()
+	if elem, ok := h.entries[string(key)]; ok {
+		entry := elem.Value.(*hybridEntry)
+		if entry.pos != nil {
+			entry.accessed.Store(true)
+		}
+		pos := entry.pos
+		h.lock.RUnlock()
+		return pos
+	}
+	pos := h.diskGet(key)
+	hasRoom := h.memorySize+int64(len(key))+hybridEntryOverhead <= h.memoryLimit
+	h.lock.RUnlock()
+
+	if pos != nil && hasRoom {
+		h.load(key)
+	}
+	return pos
+}
+
+// Delete 根据 key 删除对应的索引位置信息
+func (h *HybridIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var oldPos *data.LogRecordPos
+	onDisk := true
+	elem, ok := h.entries[string(key)]
+	if ok {
+		entry := elem.Value.(*hybridEntry)
+		if entry.pos == nil {
+			return nil, false
+		}
+		oldPos, onDisk = entry.pos, entry.onDisk
+	} else if oldPos = h.diskGet(key); oldPos == nil {
+		return nil, false
+	}
+	h.size--
+
+	// 有迭代器或者快照时不能写入磁盘，磁盘上的 key 在内存中保留删除标记
+	if onDisk && h.readers.Load() > 0 {
+		if ok {
+			entry := elem.Value.(*hybridEntry)
+			entry.pos, entry.dirty = nil, true
+			entry.accessed.Store(false)
+		} else {
+			h.add(&hybridEntry{key: string(key), dirty: true, onDisk: true})
+		}
+		return oldPos, true
+	}
+
+	if ok {
+		h.remove(elem)
+	}
+	if onDisk {
+		if err := h.tree.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket(indexBucketName).Delete(key)
+		}); err != nil {
+			panic("failed to delete index in hybrid index")
+		}
+		h.diskKeyNum--
+	}
+	return oldPos, true
+}
+
+// Size 索引中的数据量
+func (h *HybridIndex) Size() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.size
+}
+
+// Iterator 索引迭代器，复制内存中的 key 并排序，和磁盘上 B+ 树的只读事务一起在遍历时归并
+// 内存中的 key 受内存限制，不需要复制磁盘上的 key，只读事务在 Close 时释放，之前不会溢出
+func (h *HybridIndex) Iterator(reverse bool) Iterator {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	memItems := make([]*Item, 0, len(h.entries))
+	for elem := h.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*hybridEntry)
+		memItems = append(memItems, &Item{key: []byte(entry.key), pos: entry.pos})
+	}
+	// 持有锁时开启事务，和内存中的 key 是同一时刻的数据
+	return h.newIterator(memItems, reverse)
+}
+
+// Snapshot 创建当前时刻的只读视图，只复制内存中的 key，磁盘上的 key 在快照关闭之前不会变化
+// 快照上的写入只保存在快照的内存中，不影响原来的索引，使用完毕之后需要调用 Close
+func (h *HybridIndex) Snapshot() Indexer {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	entries := make(map[string]*data.LogRecordPos, len(h.entries))
+	for key, elem := range h.entries {
+		entries[key] = elem.Value.(*hybridEntry).pos
+	}
+	h.readers.Add(1)
+	return &hybridSnapshot{
+		h:       h,
+		lock:    new(sync.RWMutex),
+		entries: entries,
+		size:    h.size,
+	}
+}
+
+// Stat 内存使用情况
+func (h *HybridIndex) Stat() HybridIndexStat {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return HybridIndexStat{
+		MemorySize:   h.memorySize,
+		MemoryLimit:  h.memoryLimit,
+		MemoryKeyNum: len(h.entries),
+		DiskKeyNum:   h.diskKeyNum,
+	}
+}
+
+// Close 关闭操作，磁盘上的文件不再需要
+func (h *HybridIndex) Close() error {
+	path := h.tree.Path()
+	if err := h.tree.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// 从磁盘上读取 key 的位置，在访问方法之前必须持有互斥锁（读锁或写锁）
+func (h *HybridIndex) diskGet(key []byte) *data.LogRecordPos {
+	if h.diskKeyNum == 0 {
+		return nil
+	}
+	return h.treeGet(key)
+}
+
+// 从磁盘上的 B+ 树中读取 key 的位置
+func (h *HybridIndex) treeGet(key []byte) *data.LogRecordPos {
+	var pos *data.LogRecordPos
+	if err := h.tree.View(func(tx *bbolt.Tx) error {
+		if value := tx.Bucket(indexBucketName).Get(key); len(value) != 0 {
+			pos = data.DecodeLogRecordPos(value)
+		}
+		return nil
+	}); err != nil {
+		panic("failed to get index in hybrid index")
+	}
+	return pos
+}
+
+// 将读取到的磁盘上的 key 加载到内存中，内存没有剩余空间时不加载，不会溢出
+func (h *HybridIndex) load(key []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.entries[string(key)]; ok {
+		return
+	}
+	if h.memorySize+int64(len(key))+hybridEntryOverhead > h.memoryLimit {
+		return
+	}
+	// 释放读锁之后 key 可能已经被删除，重新从磁盘上读取
+	pos := h.diskGet(key)
+	if pos == nil {
+		return
+	}
+	entry := &hybridEntry{key: string(key), pos: pos, onDisk: true}
+	h.entries[entry.key] = h.lru.PushFront(entry)
+	h.memorySize += int64(len(entry.key)) + hybridEntryOverhead
+}
+
+// 将 key 加入内存，超过内存限制并且没有迭代器和快照时溢出，在访问方法之前必须持有互斥锁
+func (h *HybridIndex) add(entry *hybridEntry) {
+	h.entries[entry.key] = h.lru.PushFront(entry)
+	h.memorySize += int64(len(entry.key)) + hybridEntryOverhead
+	if h.memorySize > h.memoryLimit && h.readers.Load() == 0 {
+		h.spill()
+	}
+}
+
+// 从内存中移除 key，在访问方法之前必须持有互斥锁
+func (h *HybridIndex) remove(elem *list.Element) {
+	entry := h.lru.Remove(elem).(*hybridEntry)
+	delete(h.entries, entry.key)
+	h.memorySize -= int64(len(entry.key)) + hybridEntryOverhead
+}
+
+// 将最久没有访问的 key 溢出到磁盘，直到内存占用降到上限的 90%，每次溢出一批，减少写入磁盘的次数
+// 上一次溢出之后读取过的 key 移动到前面，最近写入的 key 始终保留在内存中，删除标记溢出时从磁盘上删除
+// 在访问方法之前必须持有互斥锁
+func (h *HybridIndex) spill() {
+	target := h.memoryLimit - h.memoryLimit/10
+	var evicted []*list.Element
+	size := h.memorySize
+	newest := h.lru.Front()
+	for elem := h.lru.Back(); elem != nil && elem != newest && size > target; {
+		prev := elem.Prev()
+		entry := elem.Value.(*hybridEntry)
+		switch {
+		case len(entry.key) == 0:
+			// B+ 树不支持空的 key，只保留在内存中
+		case entry.accessed.Swap(false):
+			h.lru.MoveToFront(elem)
+		default:
+			evicted = append(evicted, elem)
+			size -= int64(len(entry.key)) + hybridEntryOverhead
+		}
+		elem = prev
+	}
+
+	if err := h.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for _, elem := range evicted {
+			entry := elem.Value.(*hybridEntry)
+			if !entry.dirty {
+				continue
+			}
+			var err error
+			if entry.pos == nil {
+				err = bucket.Delete([]byte(entry.key))
+			} else {
+				err = bucket.Put([]byte(entry.key), data.EncodeLogRecordPos(entry.pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to spill index in hybrid index")
+	}
+
+	for _, elem := range evicted {
+		entry := elem.Value.(*hybridEntry)
+		if entry.pos == nil {
+			h.diskKeyNum--
+		} else if !entry.onDisk {
+			h.diskKeyNum++
+		}
+		h.remove(elem)
+	}
+}
+
+// 根据内存中的 key 创建迭代器，在访问方法之前必须持有互斥锁（读锁或写锁）
+func (h *HybridIndex) newIterator(memItems []*Item, reverse bool) *hybridIterator {
+	slices.SortFunc(memItems, func(a, b *Item) int {
+		return bytes.Compare(a.key, b.key)
+	})
+	if reverse {
+		slices.Reverse(memItems)
+	}
+
+	h.readers.Add(1)
+	tx, err := h.tree.Begin(false)
+	if err != nil {
+		panic("failed to begin a transaction")
+	}
+	hi := &hybridIterator{
+		h:        h,
+		tx:       tx,
+		cursor:   tx.Bucket(indexBucketName).Cursor(),
+		reverse:  reverse,
+		memItems: memItems,
+	}
+	hi.Rewind()
+	return hi
+}
+
+// 迭代器或者快照关闭，全部关闭之后处理期间没有溢出的 key
+func (h *HybridIndex) release() {
+	if h.readers.Add(-1) > 0 {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.readers.Load() == 0 && h.memorySize > h.memoryLimit {
+		h.spill()
+	}
+}
+
+// 混合索引的快照，内存中的部分在创建时复制，磁盘上的部分在快照关闭之前不会被修改，直接读取
+type hybridSnapshot struct {
+	h       *HybridIndex
+	lock    *sync.RWMutex
+	entries map[string]*data.LogRecordPos // 创建快照时内存中的 key，为 nil 时已经被删除
+	size    int
+	closed  bool
+}
+
+func (s *hybridSnapshot) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldPos := s.get(key)
+	if oldPos == nil {
+		s.size++
+	}
+	s.entries[string(key)] = pos
+	return oldPos
+}
+
+func (s *hybridSnapshot) Get(key []byte) *data.LogRecordPos {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.get(key)
+}
+
+func (s *hybridSnapshot) Delete(key []byte) (*data.LogRecordPos, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldPos := s.get(key)
+	if oldPos == nil {
+		return nil, false
+	}
+	s.entries[string(key)] = nil
+	s.size--
+	return oldPos, true
+}
+
+func (s *hybridSnapshot) Size() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.size
+}
+
+func (s *hybridSnapshot) Iterator(reverse bool) Iterator {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	memItems := make([]*Item, 0, len(s.entries))
+	for key, pos := range s.entries {
+		memItems = append(memItems, &Item{key: []byte(key), pos: pos})
+	}
+	return s.h.newIterator(memItems, reverse)
+}
+
+func (s *hybridSnapshot) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.h.release()
+	}
+	return nil
+}
+
+// 先查找复制的内存中的 key，没有时读取磁盘，在访问方法之前必须持有互斥锁（读锁或写锁）
+func (s *hybridSnapshot) get(key []byte) *data.LogRecordPos {
+	if pos, ok := s.entries[string(key)]; ok {
+		return pos
+	}
+	return s.h.treeGet(key)
+}
+
+// 混合索引迭代器，内存中的 key 已经按照遍历的顺序排好，和磁盘上的 cursor 归并，同时存在时以内存中的为准
+type hybridIterator struct {
+	h        *HybridIndex
+	tx       *bbolt.Tx
+	cursor   *bbolt.Cursor
+	reverse  bool
+	memItems []*Item // 内存中的 key，按照遍历的顺序排列，位置为 nil 的是删除标记
+	memIndex int     // 内存中下一个 key 的下标
+	diskKey  []byte  // cursor 当前的位置，遍历完时为 nil
+	diskPos  []byte
+	currKey  []byte
+	currPos  *data.LogRecordPos
+	fromMem  bool // 当前的 key 来自内存
+	fromDisk bool // 当前的 key 来自磁盘，和内存中的 key 相同时两边同时前进
+}
+
+func (hi *hybridIterator) Rewind() {
+	hi.memIndex = 0
+	if hi.reverse {
+		hi.diskKey, hi.diskPos = hi.cursor.Last()
+	} else {
+		hi.diskKey, hi.diskPos = hi.cursor.First()
+	}
+	hi.settle()
+}
+
+func (hi *hybridIterator) Seek(key []byte) {
+	hi.memIndex = sort.Search(len(hi.memItems), func(i int) bool {
+		if hi.reverse {
+			return bytes.Compare(hi.memItems[i].key, key) <= 0
+		}
+		return bytes.Compare(hi.memItems[i].key, key) >= 0
+	})
+	hi.diskKey, hi.diskPos = hi.cursor.Seek(key)
+	// cursor 定位到第一个大于等于 key 的位置，反向遍历时需要的是最后一个小于等于 key 的位置
+	if hi.reverse {
+		if hi.diskKey == nil {
+			hi.diskKey, hi.diskPos = hi.cursor.Last()
+		} else if bytes.Compare(hi.diskKey, key) > 0 {
+			hi.diskKey, hi.diskPos = hi.cursor.Prev()
+		}
+	}
+	hi.settle()
+}
+
+func (hi *hybridIterator) Next() {
+	hi.advance()
+	hi.settle()
+}
+
+func (hi *hybridIterator) Valid() bool {
+	return hi.fromMem || hi.fromDisk
+}
+
+func (hi *hybridIterator) Key() []byte {
+	return hi.currKey
+}
+
+func (hi *hybridIterator) Value() *data.LogRecordPos {
+	return hi.currPos
+}
+
+func (hi *hybridIterator) Close() {
+	if hi.tx == nil {
+		return
+	}
+	// 只读事务不能提交，需要回滚才会释放
+	_ = hi.tx.Rollback()
+	hi.tx = nil
+	hi.h.release()
+}
+
+// 跳过当前的 key
+func (hi *hybridIterator) advance() {
+	if hi.fromMem {
+		hi.memIndex++
+	}
+	if hi.fromDisk {
+		if hi.reverse {
+			hi.diskKey, hi.diskPos = hi.cursor.Prev()
+		} else {
+			hi.diskKey, hi.diskPos = hi.cursor.Next()
+		}
+	}
+}
+
+// 比较内存和磁盘上的下一个 key，取遍历顺序中靠前的作为当前的 key，跳过删除标记以及被它删除的磁盘上的 key
+func (hi *hybridIterator) settle() {
+	for {
+		hasMem, hasDisk := hi.memIndex < len(hi.memItems), hi.diskKey != nil
+		var cmp int
+		switch {
+		case !hasMem && !hasDisk:
+			hi.fromMem, hi.fromDisk = false, false
+			hi.currKey, hi.currPos = nil, nil
+			return
+		case !hasMem:
+			cmp = -1
+		case !hasDisk:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(hi.diskKey, hi.memItems[hi.memIndex].key)
+			if hi.reverse {
+				cmp = -cmp
+			}
+		}
+		hi.fromMem, hi.fromDisk = cmp >= 0, cmp <= 0
+		if !hi.fromMem {
+			// 事务结束之后 bbolt 返回的内存不再有效，复制一份
+			hi.currKey, hi.currPos = bytes.Clone(hi.diskKey), data.DecodeLogRecordPos(hi.diskPos)
+			return
+		}
+		if item := hi.memItems[hi.memIndex]; item.pos != nil {
+			hi.currKey, hi.currPos = item.key, item.pos
+			return
+		}
+		hi.advance()
+	}
+}
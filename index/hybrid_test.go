@@ -0,0 +1,275 @@
+package index
+
+import (
+	"fmt"
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestHybridIndex_PutGetDelete(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hybrid")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	// 内存中最多保留 10 个左右的 key
+	h := NewHybridIndex(dir, 10*(hybridEntryOverhead+10))
+	defer func() {
+		assert.Nil(t, h.Close())
+		// 关闭之后删除溢出的文件
+		_, err := os.Stat(filepath.Join(dir, HybridIndexFileName))
+		assert.True(t, os.IsNotExist(err))
+	}()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%05d", i))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, h.Put(key(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	assert.Equal(t, 100, h.Size())
+	stat := h.Stat()
+	assert.True(t, stat.MemorySize <= stat.MemoryLimit)
+	assert.True(t, stat.MemoryKeyNum < 100)
+	assert.True(t, stat.DiskKeyNum > 0)
+	assert.Equal(t, 100, stat.MemoryKeyNum+stat.DiskKeyNum)
+
+	// 溢出到磁盘上的 key 仍然可以读取、更新和删除
+	for i := 0; i < 100; i++ {
+		pos := h.Get(key(i))
+		assert.NotNil(t, pos)
+		assert.Equal(t, int64(i), pos.Offset)
+	}
+	assert.Nil(t, h.Get([]byte("not exist")))
+	oldPos := h.Put(key(0), &data.LogRecordPos{Fid: 2, Offset: 100})
+	assert.Equal(t, int64(0), oldPos.Offset)
+	assert.Equal(t, uint32(2), h.Get(key(0)).Fid)
+	for i := 1; i < 50; i++ {
+		oldPos, ok := h.Delete(key(i))
+		assert.True(t, ok)
+		assert.Equal(t, int64(i), oldPos.Offset)
+	}
+	_, ok := h.Delete(key(1))
+	assert.False(t, ok)
+	assert.Equal(t, 51, h.Size())
+
+	// 迭代器合并内存和磁盘上的 key，并按照 key 排序
+	var keys [][]byte
+	iter := h.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, 51, len(keys))
+	assert.Equal(t, key(0), keys[0])
+	assert.Equal(t, key(50), keys[1])
+	assert.Equal(t, key(99), keys[50])
+
+	iter = h.Iterator(true)
+	iter.Seek(key(60))
+	assert.Equal(t, key(60), iter.Key())
+	iter.Rewind()
+	assert.Equal(t, key(99), iter.Key())
+	iter.Close()
+}
+
+func TestHybridIndex_GetWithoutSpill(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hybrid-get")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	h := NewHybridIndex(dir, 10*(hybridEntryOverhead+10))
+	defer func() {
+		_ = h.Close()
+	}()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%05d", i))
+	}
+	for i := 0; i < 100; i++ {
+		h.Put(key(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 读取过的 key 在溢出时保留在内存中，没有读取过的最久没有访问的 key 被溢出
+	h.lock.RLock()
+	oldest := []byte(h.lru.Back().Value.(*hybridEntry).key)
+	second := []byte(h.lru.Back().Prev().Value.(*hybridEntry).key)
+	h.lock.RUnlock()
+	h.Get(oldest)
+	for i := 100; i < 105; i++ {
+		h.Put(key(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	h.lock.RLock()
+	_, oldestInMemory := h.entries[string(oldest)]
+	_, secondInMemory := h.entries[string(second)]
+	h.lock.RUnlock()
+	assert.True(t, oldestInMemory)
+	assert.False(t, secondInMemory)
+	stat := h.Stat()
+
+	// 读取磁盘上的 key 不会溢出，内存写满之后不再加载
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				pos := h.Get(key(i))
+				assert.NotNil(t, pos)
+				assert.Equal(t, int64(i), pos.Offset)
+			}
+		}()
+	}
+	wg.Wait()
+	newStat := h.Stat()
+	assert.Equal(t, stat.DiskKeyNum, newStat.DiskKeyNum)
+	assert.True(t, newStat.MemorySize <= newStat.MemoryLimit)
+	assert.True(t, newStat.MemoryKeyNum >= stat.MemoryKeyNum)
+}
+
+func TestHybridIndex_Iterator(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hybrid-iterator")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	h := NewHybridIndex(dir, 10*(hybridEntryOverhead+10))
+	defer func() {
+		_ = h.Close()
+	}()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%05d", i*2))
+	}
+	for i := 0; i < 100; i++ {
+		h.Put(key(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	// 已经溢出到磁盘上的 key 更新之后，内存和磁盘上都有，以内存中的为准
+	for i := 0; i < 5; i++ {
+		h.Put(key(i), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+	}
+
+	collect := func(iter Iterator) ([][]byte, []*data.LogRecordPos) {
+		var keys [][]byte
+		var positions []*data.LogRecordPos
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, iter.Key())
+			positions = append(positions, iter.Value())
+		}
+		return keys, positions
+	}
+
+	iter := h.Iterator(false)
+	// 迭代器打开期间不写入磁盘，删除磁盘上的 key 时在内存中保留删除标记，迭代器仍然读取到打开时的数据
+	diskKeyNum := h.Stat().DiskKeyNum
+	for i := 100; i < 200; i++ {
+		h.Put(key(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	_, ok := h.Delete(key(50))
+	assert.True(t, ok)
+	_, ok = h.Delete(key(50))
+	assert.False(t, ok)
+	assert.Nil(t, h.Get(key(50)))
+	stat := h.Stat()
+	assert.Equal(t, diskKeyNum, stat.DiskKeyNum)
+	assert.True(t, stat.MemorySize > stat.MemoryLimit)
+	keys, positions := collect(iter)
+	assert.Equal(t, 100, len(keys))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, key(i), keys[i])
+		assert.Equal(t, int64(i), positions[i].Offset)
+		if i < 5 {
+			assert.Equal(t, uint32(2), positions[i].Fid)
+		} else {
+			assert.Equal(t, uint32(1), positions[i].Fid)
+		}
+	}
+
+	// 定位到不存在的 key 时，正向取下一个，反向取上一个
+	iter.Seek([]byte("key-00011"))
+	assert.Equal(t, key(6), iter.Key())
+	iter.Close()
+	// 关闭之后溢出，删除标记从磁盘上删除
+	stat = h.Stat()
+	assert.True(t, stat.MemorySize <= stat.MemoryLimit)
+	assert.Equal(t, 199, h.Size())
+	assert.Nil(t, h.Get(key(50)))
+
+	iter = h.Iterator(true)
+	keys, _ = collect(iter)
+	assert.Equal(t, 199, len(keys))
+	assert.Equal(t, key(199), keys[0])
+	assert.Equal(t, key(0), keys[198])
+	iter.Seek([]byte("key-00011"))
+	assert.Equal(t, key(5), iter.Key())
+	iter.Seek([]byte("key-99999"))
+	assert.Equal(t, key(199), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestHybridIndex_Snapshot(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hybrid-snapshot")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	h := NewHybridIndex(dir, 10*(hybridEntryOverhead+10))
+	defer func() {
+		_ = h.Close()
+	}()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%05d", i))
+	}
+	for i := 0; i < 100; i++ {
+		h.Put(key(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 快照只复制内存中的 key，之后的写入对快照不可见
+	snapshot := h.Snapshot()
+	for i := 0; i < 100; i++ {
+		h.Put(key(i), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+	}
+	for i := 0; i < 10; i++ {
+		h.Delete(key(i))
+	}
+	h.Put(key(100), &data.LogRecordPos{Fid: 2, Offset: 100})
+	assert.Equal(t, 100, snapshot.Size())
+	for i := 0; i < 100; i++ {
+		pos := snapshot.Get(key(i))
+		assert.NotNil(t, pos)
+		assert.Equal(t, uint32(1), pos.Fid)
+	}
+	assert.Nil(t, snapshot.Get(key(100)))
+
+	// 快照上的写入不影响原来的索引
+	_, ok := snapshot.Delete(key(20))
+	assert.True(t, ok)
+	snapshot.Put(key(200), &data.LogRecordPos{Fid: 3})
+	assert.Equal(t, uint32(2), h.Get(key(20)).Fid)
+	assert.Nil(t, h.Get(key(200)))
+
+	var count int
+	iter := snapshot.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.NotEqual(t, key(20), iter.Key())
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+	assert.Nil(t, snapshot.Close())
+
+	// 快照关闭之后恢复溢出
+	stat := h.Stat()
+	assert.True(t, stat.MemorySize <= stat.MemoryLimit)
+	assert.Equal(t, 91, h.Size())
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, h.Get(key(i)))
+	}
+	for i := 10; i <= 100; i++ {
+		assert.Equal(t, uint32(2), h.Get(key(i)).Fid)
+	}
+}
@@ -39,10 +39,16 @@ const (
 
 	// BPTree B+树索引
 	BPTree
+
+	// Hash 分片的哈希索引
+	Hash
+
+	// Hybrid 内存和磁盘的混合索引
+	Hybrid
 )
 
-// NewIndexer 根据类型初始化索引
-func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
+// NewIndexer 根据类型初始化索引，memoryLimit 只用于混合索引
+func NewIndexer(typ IndexType, dirPath string, sync bool, memoryLimit int64) Indexer {
 	switch typ {
 	case Btree:
 		return NewBTree()
@@ -50,6 +56,10 @@ func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
 		return NewART()
 	case BPTree:
 		return NewBPlusTree(dirPath, sync)
+	case Hash:
+		return NewHashIndex()
+	case Hybrid:
+		return NewHybridIndex(dirPath, memoryLimit)
 	default:
 		// panic 返回
 		panic("unsupported index type")
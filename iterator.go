@@ -2,34 +2,104 @@ package tinykv
 
 import (
 	"bytes"
+	"github.com/Nuyoahch/tinykv/data"
 	"github.com/Nuyoahch/tinykv/index"
+	"time"
 )
 
 // Iterator 迭代器
 type Iterator struct {
-	indexIter index.Iterator  // 索引迭代器
-	db        *DB             // db 操作
-	options   IteratorOptions // 迭代器配置
+	indexIter index.Iterator            // 索引迭代器
+	db        *DB                       // db 操作
+	snapshot  *Snapshot                 // 所属的快照，为空时读取最新的数据
+	files     map[uint32]*data.DataFile // 迭代器引用的数据文件，保证 merge 之后仍然可以读取
+	blobs     map[uint32]*data.DataFile // 迭代器引用的 blob 文件
+	options   IteratorOptions           // 迭代器配置
+	prefetch  *prefetchIterator         // 预读 value 时包装的索引迭代器
+	lower     bound                     // 合并前缀和 LowerBound 之后的下界
+	upper     bound                     // 合并前缀和 UpperBound 之后的上界
+	exhausted bool                      // 是否已经越过了遍历方向上的结束边界
 }
 
-// NewIterator 初始化迭代器
+// 遍历的边界，key 为空时表示没有边界
+type bound struct {
+	key       []byte
+	inclusive bool
+}
+
+// NewIterator 初始化迭代器，已经过期的 key 会被跳过
+// 使用完毕之后需要调用 Close 释放引用的数据文件
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
-		db:        db,
-		indexIter: indexIter,
-		options:   opts,
+	iterator, _ := db.newIterator(opts, func() (index.Indexer, error) {
+		return db.index, nil
+	})
+	return iterator
+}
+
+// 在持有锁时通过 indexer 获取需要遍历的内存索引，并初始化迭代器
+func (db *DB) newIterator(opts IteratorOptions, indexer func() (index.Indexer, error)) (*Iterator, error) {
+	iterator := &Iterator{db: db, options: opts}
+	// 只遍历 key 时不需要引用数据文件
+	if opts.KeysOnly {
+		db.mu.RLock()
+		idx, err := indexer()
+		if err != nil {
+			db.mu.RUnlock()
+			return nil, err
+		}
+		iterator.indexIter = idx.Iterator(opts.Reverse)
+		db.mu.RUnlock()
+	} else {
+		db.mu.Lock()
+		idx, err := indexer()
+		if err != nil {
+			db.mu.Unlock()
+			return nil, err
+		}
+		iterator.indexIter = idx.Iterator(opts.Reverse)
+		iterator.files = db.acquireDataFiles()
+		iterator.blobs = db.acquireBlobFiles()
+		db.mu.Unlock()
 	}
+	iterator.init()
+	return iterator, nil
+}
+
+// 初始化边界和预读，并定位到起点
+func (it *Iterator) init() {
+	it.initBounds()
+	if it.options.PrefetchValues > 0 && !it.options.KeysOnly {
+		it.prefetch = newPrefetchIterator(it.indexIter, it.options.PrefetchValues, it.beforeEnd, it.readValue)
+		it.indexIter = it.prefetch
+	}
+	it.Rewind()
 }
 
 // Rewind 重新回到迭代器的起点，即第一个数据
+// 设置了边界时直接定位到遍历方向上的起始边界
 func (it *Iterator) Rewind() {
-	it.indexIter.Rewind()
+	it.exhausted = false
+	start := it.lower
+	if it.options.Reverse {
+		start = it.upper
+	}
+	if start.key == nil {
+		it.indexIter.Rewind()
+	} else {
+		it.seekBound(start)
+	}
 	it.skipToNext()
 }
 
 // Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据从这个 key 开始遍历
+// key 在起始边界之外时从起始边界开始遍历
 func (it *Iterator) Seek(key []byte) {
+	if it.options.Reverse && it.upper.key != nil && !it.upper.below(key) ||
+		!it.options.Reverse && it.lower.key != nil && !it.lower.above(key) {
+		it.Rewind()
+		return
+	}
+	it.exhausted = false
 	it.indexIter.Seek(key)
 	it.skipToNext()
 }
@@ -42,7 +112,7 @@ func (it *Iterator) Next() {
 
 // Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
 func (it *Iterator) Valid() bool {
-	return it.indexIter.Valid()
+	return !it.exhausted && it.indexIter.Valid()
 }
 
 // Key 当前遍历位置的 Key 数据
@@ -52,49 +122,106 @@ func (it *Iterator) Key() []byte {
 
 // Value 当前遍历位置的 Value 数据
 func (it *Iterator) Value() ([]byte, error) {
-	logRecordPos := it.indexIter.Value()
-	it.db.mu.RLock()
-	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(logRecordPos)
+	if it.options.KeysOnly {
+		return nil, ErrIteratorKeysOnly
+	}
+	if it.snapshot == nil && it.files == nil {
+		return nil, ErrIteratorClosed
+	}
+	if it.prefetch != nil {
+		return it.prefetch.value()
+	}
+	return it.readValue(it.indexIter.Value())
+}
+
+// 根据索引信息读取 value
+func (it *Iterator) readValue(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	if it.snapshot != nil {
+		return it.snapshot.getValueByPosition(logRecordPos)
+	}
+	return getValueFromDataFile(it.files[logRecordPos.Fid], it.blobs, logRecordPos)
 }
 
 // Close 关闭迭代器，释放相应资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.files != nil {
+		it.db.mu.Lock()
+		it.db.releaseDataFiles(it.files)
+		it.db.releaseDataFiles(it.blobs)
+		it.db.mu.Unlock()
+		it.files, it.blobs = nil, nil
+	}
 }
 
-// skipToNext 将迭代器移动到“下一个满足前缀条件的 key ”上
-func (it *Iterator) skipToNext() {
-	// 取出用户在迭代器选项中设置的前缀长度。
-	// 如果 prefix 长度为 0，说明没有设置任何前缀过滤条件，
-	// 此时不需要做额外的跳过操作，直接返回即可。
-	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
+// 合并前缀和用户设置的边界，前缀相当于 [Prefix, Prefix 的后继) 的范围
+func (it *Iterator) initBounds() {
+	opts := it.options
+	it.lower = bound{key: opts.LowerBound, inclusive: !opts.LowerExclusive}
+	it.upper = bound{key: opts.UpperBound, inclusive: opts.UpperInclusive}
+	if len(opts.Prefix) == 0 {
 		return
 	}
+	if it.lower.key == nil || bytes.Compare(opts.Prefix, it.lower.key) > 0 {
+		it.lower = bound{key: opts.Prefix, inclusive: true}
+	}
+	if end := prefixSuccessor(opts.Prefix); end != nil &&
+		(it.upper.key == nil || bytes.Compare(end, it.upper.key) <= 0) {
+		it.upper = bound{key: end, inclusive: false}
+	}
+}
+
+// 定位到起始边界上第一个满足条件的 key
+func (it *Iterator) seekBound(b bound) {
+	it.indexIter.Seek(b.key)
+	if !b.inclusive && it.indexIter.Valid() && bytes.Equal(it.indexIter.Key(), b.key) {
+		it.indexIter.Next()
+	}
+}
 
-	// 从当前 indexIter 所在位置开始，向后遍历：
-	// - it.indexIter.Valid() 用来判断当前迭代器是否还在有效范围内；
-	// - it.indexIter.Next() 在每次循环后向后移动一位。
+// skipToNext 将迭代器移动到下一个没有过期的 key 上，越过结束边界之后迭代器失效
+func (it *Iterator) skipToNext() {
+	now := time.Now().UnixNano()
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
-		// 取出当前索引迭代器指向的 key。
-		key := it.indexIter.Key()
-
-		// 如果当前 key 的长度小于前缀长度，那它肯定不可能以该前缀开头，
-		// 直接跳过（继续下一轮循环）。
-		// 如果当前 key 的长度 >= 前缀长度，再去比较前缀是否相等。
-		if prefixLen <= len(key) &&
-			// 使用 bytes.Compare 比较：
-			//   - 返回 0 表示相等，
-			bytes.Equal(it.options.Prefix, key[:prefixLen]) {
-
-			// 找到第一个满足前缀条件的 key 了，直接 break。
-			// 此时 indexIter 将停留在这个 key 上，供外部使用。
-			break
+		if !it.beforeEnd(it.indexIter.Key()) {
+			it.exhausted = true
+			return
+		}
+		// 已经过期的 key 对外不可见，直接跳过
+		if !it.indexIter.Value().IsExpired(now) {
+			return
 		}
 	}
+}
 
-	// 如果循环结束后没有 break（即没找到满足条件的 key），
-	// 那么 indexIter.Valid() 很可能已经为 false，
-	// 表示已经遍历到末尾，后续调用方会根据 Valid() 判断迭代是否结束。
+// key 是否还没有越过遍历方向上的结束边界
+func (it *Iterator) beforeEnd(key []byte) bool {
+	if it.options.Reverse {
+		return it.lower.key == nil || it.lower.above(key)
+	}
+	return it.upper.key == nil || it.upper.below(key)
+}
+
+// key 是否在上界以内，用于上界时调用
+func (b bound) below(key []byte) bool {
+	cmp := bytes.Compare(key, b.key)
+	return cmp < 0 || cmp == 0 && b.inclusive
+}
+
+// key 是否在下界以内，用于下界时调用
+func (b bound) above(key []byte) bool {
+	cmp := bytes.Compare(key, b.key)
+	return cmp > 0 || cmp == 0 && b.inclusive
+}
+
+// 以 prefix 为前缀的 key 的上界（不包含），prefix 全部为 0xff 时没有上界，返回 nil
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte(nil), prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
 }
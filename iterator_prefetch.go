@@ -0,0 +1,129 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/index"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 每个预读的 goroutine 一次读取的 value 数量
+const prefetchBatchPerWorker = 32
+
+// 预读 value 的索引迭代器
+// 每次从底层的索引迭代器中取出一批 key，按照文件和偏移排序之后分给多个 goroutine 顺序读取
+type prefetchIterator struct {
+	index.Iterator                                          // 底层的索引迭代器，总是指向已经取出的最后一个 key 之后
+	workers        int                                      // 并发读取的 goroutine 数量
+	inRange        func(key []byte) bool                    // 越过迭代器的结束边界之后不再预读
+	read           func(*data.LogRecordPos) ([]byte, error) // 读取 value 的方法
+	entries        []*prefetchEntry                         // 当前取出的一批 key
+	idx            int                                      // 当前遍历的位置
+}
+
+// 预读的一条数据
+type prefetchEntry struct {
+	key   []byte
+	pos   *data.LogRecordPos
+	value []byte
+	err   error
+}
+
+func newPrefetchIterator(iter index.Iterator, workers int, inRange func(key []byte) bool,
+	read func(*data.LogRecordPos) ([]byte, error)) *prefetchIterator {
+	return &prefetchIterator{Iterator: iter, workers: workers, inRange: inRange, read: read}
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (pi *prefetchIterator) Rewind() {
+	pi.Iterator.Rewind()
+	pi.fill()
+}
+
+// Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据从这个 key 开始遍历
+func (pi *prefetchIterator) Seek(key []byte) {
+	pi.Iterator.Seek(key)
+	pi.fill()
+}
+
+// Next 跳转到下一个 key
+func (pi *prefetchIterator) Next() {
+	pi.idx++
+	if pi.idx >= len(pi.entries) {
+		pi.fill()
+	}
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (pi *prefetchIterator) Valid() bool {
+	return pi.idx < len(pi.entries)
+}
+
+// Key 当前遍历位置的 Key 数据
+func (pi *prefetchIterator) Key() []byte {
+	return pi.entries[pi.idx].key
+}
+
+// Value 当前遍历位置的位置索引信息
+func (pi *prefetchIterator) Value() *data.LogRecordPos {
+	return pi.entries[pi.idx].pos
+}
+
+// 当前遍历位置预读的 value
+func (pi *prefetchIterator) value() ([]byte, error) {
+	entry := pi.entries[pi.idx]
+	return entry.value, entry.err
+}
+
+// 从底层的索引迭代器中取出下一批 key，并发读取对应的 value
+func (pi *prefetchIterator) fill() {
+	pi.entries, pi.idx = pi.entries[:0], 0
+	batch := pi.workers * prefetchBatchPerWorker
+	for ; pi.Iterator.Valid() && len(pi.entries) < batch; pi.Iterator.Next() {
+		// 保留越过边界的第一个 key，迭代器通过它判断遍历结束
+		if len(pi.entries) > 0 && !pi.inRange(pi.entries[len(pi.entries)-1].key) {
+			break
+		}
+		pi.entries = append(pi.entries, &prefetchEntry{
+			key: append([]byte(nil), pi.Iterator.Key()...),
+			pos: pi.Iterator.Value(),
+		})
+	}
+
+	// 已经过期和越过边界的 key 会被跳过，不需要读取
+	now := time.Now().UnixNano()
+	var pending []*prefetchEntry
+	for _, entry := range pi.entries {
+		if !entry.pos.IsExpired(now) && pi.inRange(entry.key) {
+			pending = append(pending, entry)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	// 按照文件和偏移排序，每个 goroutine 读取连续的一段，尽量顺序访问磁盘
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].pos.Fid != pending[j].pos.Fid {
+			return pending[i].pos.Fid < pending[j].pos.Fid
+		}
+		return pending[i].pos.Offset < pending[j].pos.Offset
+	})
+	chunk := (len(pending) + pi.workers - 1) / pi.workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(pending); start += chunk {
+		end := start + chunk
+		if end > len(pending) {
+			end = len(pending)
+		}
+		wg.Add(1)
+		go func(entries []*prefetchEntry) {
+			defer wg.Done()
+			for _, entry := range entries {
+				entry.value, entry.err = pi.read(entry.pos)
+			}
+		}(pending[start:end])
+	}
+	wg.Wait()
+}
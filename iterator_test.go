@@ -3,8 +3,10 @@ package tinykv
 import (
 	"github.com/Nuyoahch/tinykv/utils"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestDB_NewIterator(t *testing.T) {
@@ -92,3 +94,169 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 		assert.NotNil(t, iter3.Key())
 	}
 }
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	keysOf := func(iter *Iterator) []string {
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	for _, indexType := range []IndexerType{BTree, ART, BPlusTree, Hash, Hybrid} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-iterator-bounds")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for _, key := range []string{"a", "b", "ba", "bb", "c", "d", "e"} {
+			err := db.Put([]byte(key), utils.RandomValue(10))
+			assert.Nil(t, err)
+		}
+
+		cases := []struct {
+			opts IteratorOptions
+			keys []string
+		}{
+			{IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d")}, []string{"b", "ba", "bb", "c"}},
+			{IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d"), LowerExclusive: true, UpperInclusive: true}, []string{"ba", "bb", "c", "d"}},
+			{IteratorOptions{LowerBound: []byte("bz")}, []string{"c", "d", "e"}},
+			{IteratorOptions{UpperBound: []byte("b")}, []string{"a"}},
+			{IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d"), Reverse: true}, []string{"c", "bb", "ba", "b"}},
+			{IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d"), Reverse: true, LowerExclusive: true, UpperInclusive: true}, []string{"d", "c", "bb", "ba"}},
+			{IteratorOptions{Prefix: []byte("b"), Reverse: true}, []string{"bb", "ba", "b"}},
+			{IteratorOptions{Prefix: []byte("b"), LowerBound: []byte("ba")}, []string{"ba", "bb"}},
+			{IteratorOptions{LowerBound: []byte("x")}, nil},
+		}
+		for _, c := range cases {
+			iter := db.NewIterator(c.opts)
+			assert.Equal(t, c.keys, keysOf(iter), "index %d, options %+v", indexType, c.opts)
+			iter.Rewind()
+			assert.Equal(t, c.keys, keysOf(iter), "index %d, options %+v", indexType, c.opts)
+			iter.Close()
+		}
+
+		// Seek 不会越过起始边界
+		iter := db.NewIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d")})
+		iter.Seek([]byte("a"))
+		assert.Equal(t, []string{"b", "ba", "bb", "c"}, keysOf(iter))
+		iter.Seek([]byte("bb"))
+		assert.Equal(t, []string{"bb", "c"}, keysOf(iter))
+		iter.Close()
+
+		iter = db.NewIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d"), Reverse: true})
+		iter.Seek([]byte("z"))
+		assert.Equal(t, []string{"c", "bb", "ba", "b"}, keysOf(iter))
+		iter.Seek([]byte("bb"))
+		assert.Equal(t, []string{"bb", "ba", "b"}, keysOf(iter))
+		iter.Close()
+
+		destroyDB(db)
+	}
+}
+
+func TestDB_Iterator_KeysOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-keys-only")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	iter := db.NewIterator(IteratorOptions{KeysOnly: true, PrefetchValues: 4})
+	defer iter.Close()
+	assert.Nil(t, iter.files)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(count), iter.Key())
+		_, err := iter.Value()
+		assert.Equal(t, ErrIteratorKeysOnly, err)
+		count++
+	}
+	assert.Equal(t, 100, count)
+}
+
+func TestDB_Iterator_PrefetchValues(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-prefetch")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 乱序写入，value 分布在多个数据文件中
+	values := make(map[string][]byte)
+	for _, i := range rand.Perm(1000) {
+		key, value := utils.GetTestKey(i), utils.RandomValue(128)
+		values[string(key)] = value
+		err := db.Put(key, value)
+		assert.Nil(t, err)
+	}
+	err = db.PutWithTTL(utils.GetTestKey(500), utils.RandomValue(10), time.Nanosecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond)
+
+	check := func(iterOpts IteratorOptions, from, to int) {
+		iter := db.NewIterator(iterOpts)
+		defer iter.Close()
+		var keys [][]byte
+		for ; iter.Valid(); iter.Next() {
+			val, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, values[string(iter.Key())], val)
+			keys = append(keys, iter.Key())
+		}
+		var expected [][]byte
+		for i := from; i != to; {
+			if i != 500 {
+				expected = append(expected, utils.GetTestKey(i))
+			}
+			if from < to {
+				i++
+			} else {
+				i--
+			}
+		}
+		assert.Equal(t, expected, keys)
+	}
+	check(IteratorOptions{PrefetchValues: 4}, 0, 1000)
+	check(IteratorOptions{PrefetchValues: 1, Reverse: true}, 999, -1)
+	check(IteratorOptions{PrefetchValues: 8, LowerBound: utils.GetTestKey(100), UpperBound: utils.GetTestKey(700)}, 100, 700)
+
+	// Seek 之后重新预读
+	iter := db.NewIterator(IteratorOptions{PrefetchValues: 2})
+	iter.Seek(utils.GetTestKey(900))
+	assert.Equal(t, utils.GetTestKey(900), iter.Key())
+	val, err := iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, values[string(utils.GetTestKey(900))], val)
+	iter.Close()
+	_, err = iter.Value()
+	assert.Equal(t, ErrIteratorClosed, err)
+
+	// 快照上的迭代器
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+	iter = snapshot.NewIterator(IteratorOptions{PrefetchValues: 4})
+	defer iter.Close()
+	var count int
+	for ; iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(iter.Key())], val)
+		count++
+	}
+	assert.Equal(t, 999, count)
+}
@@ -1,7 +1,9 @@
 package tinykv
 
 import (
+	"context"
 	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/fio"
 	"github.com/Nuyoahch/tinykv/utils"
 	"io"
 	"os"
@@ -9,6 +11,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // merge 相关变量
@@ -19,12 +23,24 @@ const (
 )
 
 // Merge 清理无效数据，生成 Hint 文件
+// merge 完成之后直接在线安装新的数据文件和 Hint 文件，不需要重启数据库
 func (db *DB) Merge() error {
+	return db.MergeContext(context.Background())
+}
+
+// MergeContext 和 Merge 相同，等待锁的过程中以及重写每条记录之前检查 ctx
+// 在安装 merge 文件之前被取消时删除 merge 目录，数据库保持 merge 之前的状态
+func (db *DB) MergeContext(ctx context.Context) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	// 如果数据库为空，则直接返回
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
+	if err := db.lockContext(ctx); err != nil {
+		return err
+	}
 	// 如果 merge 正在进行当中，则直接返回
 	if db.isMerging {
 		db.mu.Unlock()
@@ -35,12 +51,16 @@ func (db *DB) Merge() error {
 		db.isMerging = false
 	}()
 
-	// 查看可 Merge 的容量是否达到了阈值
+	// 已经过期的 key 不再需要保留，从索引中移除并计入可回收的空间
+	db.removeExpiredKeys()
+
+	// 查看可 Merge 的容量是否达到了阈值，blob 文件单独回收，不计算在内
 	totalSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
+	totalSize -= db.blobFilesSize()
 	if float32(db.reclaimableSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
@@ -56,6 +76,8 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return ErrNoEnoughDiskForMerge
 	}
+	// 记录 merge 开始时的可回收空间，安装完成之后这部分空间都已经被回收
+	reclaimedSize := db.reclaimableSize
 
 	// 持久化当前活跃文件
 	if err := db.activeFile.Sync(); err != nil {
@@ -95,36 +117,71 @@ func (db *DB) Merge() error {
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
 		return err
 	}
+	// 没有完成时关闭打开的文件并删除 merge 目录，不留下不完整的 merge 文件
+	var (
+		mergeDB  *DB
+		hintFile *data.DataFile
+		finished bool
+	)
+	defer func() {
+		if finished {
+			return
+		}
+		if hintFile != nil {
+			_ = hintFile.Close()
+		}
+		if mergeDB != nil {
+			_ = mergeDB.Close()
+		}
+		_ = os.RemoveAll(mergePath)
+	}()
+
 	// 打开一个新的临时 bitcask 实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
-	mergeDB, err := Open(mergeOptions)
+	mergeOptions.AutoMergeInterval = 0
+	// 已经在 blob 文件中的 value 只重写数据文件中的位置
+	mergeOptions.BlobThreshold = 0
+	mergeDB, err = Open(mergeOptions)
 	if err != nil {
 		return err
 	}
 
 	// 打开 hint 文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err = data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		offset := dataFile.HeaderSize
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
+				// 启动时已经跳过的损坏记录，merge 时同样跳过，无法确定长度时丢弃文件剩余的部分
+				if db.options.RecoveryMode == RecoverySkipCorrupt && isCorruptRecordErr(err) {
+					if size > 0 {
+						offset += size
+						continue
+					}
+					break
+				}
 				return err
 			}
 			// 解析拿到实际的 key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
-			// 和内存中的索引位置进行比较，如果有效则重写
+			logRecordPos := db.mergeIndexGet(realKey, logRecord.Namespace)
+			// 和内存中的索引位置进行比较，如果有效且没有过期则重写
 			if logRecordPos != nil &&
+				!logRecordPos.IsExpired(time.Now().UnixNano()) &&
 				logRecordPos.Fid == dataFile.FileId &&
 				logRecordPos.Offset == offset {
 				// 清除事务标记
@@ -134,7 +191,7 @@ func (db *DB) Merge() error {
 					return err
 				}
 				// 将当前位置索引写到 Hint 文件当中
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				if err := hintFile.WriteHintRecord(realKey, logRecord.Namespace, pos); err != nil {
 					return err
 				}
 			}
@@ -147,27 +204,187 @@ func (db *DB) Merge() error {
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
+	err = hintFile.Close()
+	hintFile = nil
+	if err != nil {
+		return err
+	}
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	err = mergeDB.Close()
+	mergeDB = nil
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	// 写标识 merge 完成的文件
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
 	if err != nil {
 		return err
 	}
+	mergeFinishedFile.Cipher = db.cipher
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
+	if err := mergeFinishedFile.WriteLogRecord(mergeFinRecord); err != nil {
 		return err
 	}
 	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
+	if err := mergeFinishedFile.Close(); err != nil {
+		return err
+	}
 
+	// 写入完成标识之后，即使安装失败，重启时也会继续安装
+	finished = true
+	if err := db.installMergeFiles(nonMergeFileId, reclaimedSize); err != nil {
+		return err
+	}
+	// 轮换密钥之后 blob 文件中的记录同样需要使用新的密钥重新加密
+	if db.cipher != nil {
+		return db.rotateBlobFiles()
+	}
+	return nil
+}
+
+// merge 时查找 key 在所属命名空间中的索引位置，命名空间已经删除时返回 nil
+// 命名空间的索引在删除之后会被关闭，需要持有读锁
+func (db *DB) mergeIndexGet(key []byte, namespace uint32) *data.LogRecordPos {
+	if namespace == 0 {
+		return db.index.Get(key)
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if idx := db.indexOf(namespace); idx != nil {
+		return idx.Get(key)
+	}
+	return nil
+}
+
+// 在线安装 merge 生成的数据文件和 hint 文件，不需要重启数据库
+// 替换掉的旧数据文件在没有快照和迭代器引用之后才会关闭
+func (db *DB) installMergeFiles(nonMergeFileId uint32, reclaimedSize int64) error {
+	mergePath := db.getMergePath()
+	defer func() {
+		_ = os.RemoveAll(mergePath)
+	}()
+
+	dirEntries, err := os.ReadDir(mergePath)
+	if err != nil {
+		return err
+	}
+	// 只需要 merge 生成的数据文件和 hint 文件，标识 merge 完成的文件放到最后移动
+	var mergeFileNames []string
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) || entry.Name() == data.HintFileName {
+			mergeFileNames = append(mergeFileNames, entry.Name())
+		}
+	}
+	mergeFileNames = append(mergeFileNames, data.MergeFinishedFileName)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.beginRemapFiles()
+	defer db.endRemapFiles()
+
+	// 旧的数据文件不再对外提供读取
+	for fid, file := range db.olderFiles {
+		if fid < nonMergeFileId {
+			delete(db.olderFiles, fid)
+			db.retireDataFile(file)
+		}
+	}
+
+	// 将新的数据文件移动到数据目录中
+	if err := db.moveMergeFiles(mergePath, mergeFileNames, nonMergeFileId); err != nil {
+		return err
+	}
+
+	// 打开新的数据文件
+	for _, fileName := range mergeFileNames {
+		if !strings.HasSuffix(fileName, data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.Split(fileName, ".")[0])
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fileId), fio.StandardFile)
+		if err != nil {
+			return err
+		}
+		dataFile.Cipher = db.cipher
+		db.olderFiles[uint32(fileId)] = dataFile
+	}
+
+	// 根据 hint 文件将索引指向新的位置
+	// 位置仍然在旧数据文件中的 key 说明在 merge 期间没有被修改过，可以直接替换
+	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	hintFile.Cipher = db.cipher
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	offset := hintFile.HeaderSize
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		idx := db.indexOf(logRecord.Namespace)
+		if idx == nil {
+			offset += size
+			continue
+		}
+		if oldPos := idx.Get(logRecord.Key); oldPos != nil && oldPos.Fid < nonMergeFileId {
+			idx.Put(logRecord.Key, pos)
+		}
+		offset += size
+	}
+	// merge 期间过期而没有重写的 key 仍然指向旧的数据文件，一并移除
+	db.removeExpiredKeys()
+
+	// merge 开始时记录的可回收空间已经被回收
+	db.reclaimableSize -= reclaimedSize
+	if db.reclaimableSize < 0 {
+		db.reclaimableSize = 0
+	}
+	return nil
+}
+
+// 删除 id 小于 nonMergeFileId 的旧数据文件，并将 merge 目录中的文件移动到数据目录中
+func (db *DB) moveMergeFiles(mergePath string, mergeFileNames []string, nonMergeFileId uint32) error {
+	// 删除旧的数据文件
+	var fileId uint32 = 0
+	for ; fileId < nonMergeFileId; fileId++ {
+		fileName := data.GetDataFileName(db.options.DirPath, fileId)
+		if _, err := os.Stat(fileName); err == nil {
+			if err := os.Remove(fileName); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 将新的数据文件移动到数据目录中
+	for _, fileName := range mergeFileNames {
+		srcPath := filepath.Join(mergePath, fileName)
+		destPath := filepath.Join(db.options.DirPath, fileName)
+		if err := os.Rename(srcPath, destPath); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -218,27 +435,7 @@ func (db *DB) loadMergeFiles() error {
 	if err != nil {
 		return nil
 	}
-
-	// 删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
-			}
-		}
-	}
-
-	// 将新的数据文件移动到数据目录中
-	for _, fileName := range mergeFileNames {
-		srcPath := filepath.Join(mergePath, fileName)
-		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
-			return err
-		}
-	}
-	return nil
+	return db.moveMergeFiles(mergePath, mergeFileNames, nonMergeFileId)
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
@@ -246,7 +443,11 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	mergeFinishedFile.Cipher = db.cipher
+	record, _, err := mergeFinishedFile.ReadLogRecord(mergeFinishedFile.HeaderSize)
 	if err != nil {
 		return 0, err
 	}
@@ -270,9 +471,11 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 
 	// 读取文件中的索引
-	var offset int64 = 0
+	offset := hintFile.HeaderSize
+	now := time.Now().UnixNano()
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -284,7 +487,13 @@ func (db *DB) loadIndexFromHintFile() error {
 
 		// 解码拿到实际的位置索引
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		// 已经过期的数据和已经删除的命名空间中的数据不再加载到索引中
+		idx := db.indexOf(logRecord.Namespace)
+		if idx == nil || pos.IsExpired(now) {
+			db.reclaim(pos)
+		} else {
+			idx.Put(logRecord.Key, pos)
+		}
 		offset += size
 	}
 	return nil
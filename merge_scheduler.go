@@ -0,0 +1,142 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/utils"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 后台自动 merge 调度
+// 按照 AutoMergeInterval 定期检查可回收空间的比例，在允许的时间窗口内达到阈值时执行 merge
+// 数据文件和 blob 文件分别按照 DataFileMergeRatio 和 BlobMergeRatio 判断
+type mergeScheduler struct {
+	db      *DB
+	mu      *sync.Mutex   // 执行 merge 时持有，用于暂停时等待正在进行的 merge 完成
+	paused  atomic.Bool   // 是否暂停
+	closeCh chan struct{} // 关闭信号
+	wg      *sync.WaitGroup
+}
+
+// 初始化自动 merge 调度
+func newMergeScheduler(db *DB) *mergeScheduler {
+	return &mergeScheduler{
+		db:      db,
+		mu:      new(sync.Mutex),
+		closeCh: make(chan struct{}),
+		wg:      new(sync.WaitGroup),
+	}
+}
+
+// PauseAutoMerge 暂停后台自动 merge，如果有正在进行的 merge，会等待其完成之后返回
+func (db *DB) PauseAutoMerge() {
+	if db.mergeScheduler == nil {
+		return
+	}
+	db.mergeScheduler.paused.Store(true)
+	db.mergeScheduler.mu.Lock()
+	db.mergeScheduler.mu.Unlock()
+}
+
+// ResumeAutoMerge 恢复后台自动 merge
+func (db *DB) ResumeAutoMerge() {
+	if db.mergeScheduler == nil {
+		return
+	}
+	db.mergeScheduler.paused.Store(false)
+}
+
+// 启动后台调度
+func (ms *mergeScheduler) start() {
+	ms.wg.Add(1)
+	go ms.run()
+}
+
+// 停止后台调度，等待正在进行的 merge 完成
+func (ms *mergeScheduler) stop() {
+	select {
+	case <-ms.closeCh:
+		return
+	default:
+		close(ms.closeCh)
+	}
+	ms.wg.Wait()
+}
+
+func (ms *mergeScheduler) run() {
+	defer ms.wg.Done()
+	ticker := time.NewTicker(ms.db.options.AutoMergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ms.closeCh:
+			return
+		case now := <-ticker.C:
+			ms.tryMerge(now)
+		}
+	}
+}
+
+// 检查是否满足条件，满足时执行一次 merge
+// merge 失败时不做处理，等待下一次检查时重试
+func (ms *mergeScheduler) tryMerge(now time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.paused.Load() || !ms.inWindow(now) {
+		return
+	}
+	if ms.reachRatio() {
+		_ = ms.db.Merge()
+	}
+	if ms.reachBlobRatio() {
+		_ = ms.db.MergeBlobs()
+	}
+}
+
+// 判断当前时间是否在允许 merge 的时间窗口内
+func (ms *mergeScheduler) inWindow(now time.Time) bool {
+	start, end := ms.db.options.AutoMergeWindowStart, ms.db.options.AutoMergeWindowEnd
+	if start == end {
+		return true
+	}
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+	if start < end {
+		return offset >= start && offset < end
+	}
+	// 时间窗口跨越零点
+	return offset >= start || offset < end
+}
+
+// 判断可回收空间的比例是否达到了阈值
+func (ms *mergeScheduler) reachRatio() bool {
+	ms.db.mu.RLock()
+	reclaimableSize, blobSize := ms.db.reclaimableSize, ms.db.blobFilesSize()
+	ms.db.mu.RUnlock()
+	if reclaimableSize <= 0 {
+		return false
+	}
+
+	totalSize, err := utils.DirSize(ms.db.options.DirPath)
+	if err != nil {
+		return false
+	}
+	totalSize -= blobSize
+	if totalSize <= 0 {
+		return false
+	}
+	return float32(reclaimableSize)/float32(totalSize) >= ms.db.options.DataFileMergeRatio
+}
+
+// 判断 blob 文件中可回收空间的比例是否达到了阈值
+func (ms *mergeScheduler) reachBlobRatio() bool {
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+	totalSize := ms.db.blobFilesSize()
+	if ms.db.blobReclaimableSize <= 0 || totalSize == 0 {
+		return false
+	}
+	return float32(ms.db.blobReclaimableSize)/float32(totalSize) >= ms.db.options.BlobMergeRatio
+}
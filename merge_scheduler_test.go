@@ -0,0 +1,138 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0.5
+	opts.AutoMergeInterval = 20 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 暂停之后不会触发 merge
+	db.PauseAutoMerge()
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(512))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 8000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	reclaimableSize := db.Stat().ReclaimableSize
+	assert.True(t, reclaimableSize > 0)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, reclaimableSize, db.Stat().ReclaimableSize)
+
+	// 恢复之后自动 merge，并在线安装新的数据文件
+	diskSize := db.Stat().DiskSize
+	db.ResumeAutoMerge()
+	assert.Eventually(t, func() bool {
+		return db.Stat().ReclaimableSize == 0
+	}, 5*time.Second, 20*time.Millisecond)
+	db.PauseAutoMerge()
+	assert.True(t, db.Stat().DiskSize < diskSize)
+
+	// 不需要重启，数据依然可以正常读取
+	keys := db.ListKeys()
+	assert.Equal(t, 2000, len(keys))
+	for i := 0; i < 8000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	for i := 8000; i < 10000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, 2000, len(db2.ListKeys()))
+	for i := 8000; i < 10000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_AutoMerge_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0.5
+	opts.AutoMergeInterval = 20 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	db.PauseAutoMerge()
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	snap := db.NewSnapshot()
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 之后快照引用的旧数据文件依然可以读取
+	db.ResumeAutoMerge()
+	assert.Eventually(t, func() bool {
+		return db.Stat().ReclaimableSize == 0
+	}, 5*time.Second, 20*time.Millisecond)
+	db.PauseAutoMerge()
+	assert.True(t, len(db.retiredFiles) > 0)
+	for i := 0; i < 10000; i++ {
+		val, err := snap.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// 释放之后旧的数据文件被关闭
+	snap.Release()
+	assert.Equal(t, 0, len(db.retiredFiles))
+}
+
+func TestMergeScheduler_InWindow(t *testing.T) {
+	db := &DB{options: DefaultOptions}
+	ms := newMergeScheduler(db)
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	// 不限制时间
+	assert.True(t, ms.inWindow(day.Add(13*time.Hour)))
+
+	// 凌晨 2 点到 4 点
+	db.options.AutoMergeWindowStart = 2 * time.Hour
+	db.options.AutoMergeWindowEnd = 4 * time.Hour
+	assert.True(t, ms.inWindow(day.Add(3*time.Hour)))
+	assert.False(t, ms.inWindow(day.Add(4*time.Hour)))
+	assert.False(t, ms.inWindow(day.Add(13*time.Hour)))
+
+	// 跨越零点，晚上 23 点到凌晨 1 点
+	db.options.AutoMergeWindowStart = 23 * time.Hour
+	db.options.AutoMergeWindowEnd = time.Hour
+	assert.True(t, ms.inWindow(day.Add(23*time.Hour+30*time.Minute)))
+	assert.True(t, ms.inWindow(day.Add(30*time.Minute)))
+	assert.False(t, ms.inWindow(day.Add(12*time.Hour)))
+}
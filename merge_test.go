@@ -1,11 +1,15 @@
 package tinykv
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/Nuyoahch/tinykv/data"
 	"github.com/Nuyoahch/tinykv/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"sync"
 	"testing"
+	"time"
 )
 
 // 没有任何数据的情况下进行 merge
@@ -201,3 +205,241 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 有过期的数据
+func TestDB_Merge_Expired(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-expired")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(1024), 100*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	for i := 10000; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	time.Sleep(150 * time.Millisecond)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	// 过期的数据在 merge 时被回收
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	assert.Equal(t, 10000, len(db.ListKeys()))
+
+	//	重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 10000, len(keys))
+	assert.Equal(t, int64(0), db2.Stat().ReclaimableSize)
+	for i := 0; i < 10000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+}
+
+// merge 之后不需要重启，直接读取新的数据文件
+func TestDB_Merge_Online(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 40000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 之前创建的迭代器依然可以读取旧的数据文件
+	iter := db.NewIterator(DefaultIteratorOptions)
+	diskSize := db.Stat().DiskSize
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, db.Stat().DiskSize < diskSize)
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	for i := 0; i < 40000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	for i := 40000; i < 50000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	var count int
+	assert.True(t, len(db.retiredFiles) > 0)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), val)
+		count++
+	}
+	assert.Equal(t, 10000, count)
+	iter.Close()
+	assert.Equal(t, 0, len(db.retiredFiles))
+
+	// merge 之后继续写入，再次 merge
+	for i := 40000; i < 45000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new-value"))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, len(db2.ListKeys()))
+	for i := 40000; i < 45000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new-value"), val)
+	}
+	for i := 45000; i < 50000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+// merge 时按照当前的配置重新压缩
+func TestDB_Merge_Recompress(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-recompress")
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := bytes.Repeat([]byte("bitcask-kv-go"), 100)
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	opts.Compression = ZstdCompression
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	diskSize := db2.Stat().DiskSize
+	err = db2.Merge()
+	assert.Nil(t, err)
+	assert.True(t, db2.Stat().DiskSize < diskSize/10)
+
+	for i := 0; i < 10000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+// 更换密钥之后，merge 使用新的密钥重新加密所有数据，包括 blob 文件
+func TestDB_Merge_RotateKey(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-rotate-key")
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	opts.BlobThreshold = 256
+	opts.BlobFileSize = 64 * 1024
+	provider := &data.StaticKeyProvider{
+		Keys: map[uint32][]byte{
+			1: []byte("0123456789abcdef0123456789abcdef"),
+			2: []byte("fedcba9876543210fedcba9876543210"),
+		},
+		CurrentId: 1,
+	}
+	opts.Encryption = provider
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	blobValue := func(i int) []byte {
+		return bytes.Repeat(utils.GetTestKey(i), 50)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Put([]byte(fmt.Sprintf("blob-%d", i)), blobValue(i))
+		assert.Nil(t, err)
+	}
+
+	// 轮换密钥，新写入的数据使用新的密钥
+	provider.CurrentId = 2
+	for i := 10000; i < 11000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 100; i < 200; i++ {
+		err := db.Put([]byte(fmt.Sprintf("blob-%d", i)), blobValue(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 只包含新的密钥加密的记录的 blob 文件不需要重写
+	blobFiles := db.Stat().BlobFileNum
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, blobFiles, db.Stat().BlobFileNum)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// merge 之后不再需要旧的密钥
+	opts.Encryption = &data.StaticKeyProvider{
+		Keys:      map[uint32][]byte{2: provider.Keys[2]},
+		CurrentId: 2,
+	}
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 11200, len(db2.ListKeys()))
+	for i := 0; i < 11000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	for i := 0; i < 200; i++ {
+		val, err := db2.Get([]byte(fmt.Sprintf("blob-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, blobValue(i), val)
+	}
+}
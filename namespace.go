@@ -0,0 +1,420 @@
+package tinykv
+
+import (
+	"fmt"
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/index"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 命名空间
+// 同一个 DB 中的命名空间共享数据文件，每个命名空间有单独的内存索引，key 互不影响
+// 命名空间的记录带有命名空间 id，默认命名空间的 id 为 0，记录的编码和之前保持一致
+// 命名空间的名称和 id 保存在 namespaces 文件中，删除命名空间时只需要更新这个文件并丢弃内存索引，
+// 数据文件中剩余的记录在加载时被跳过，merge 时回收
+
+const (
+	// namespaces 文件中保存下一个命名空间 id 的记录
+	namespaceNextIdKey = "next.id"
+	// B+ 树索引和混合索引时，命名空间的索引文件所在的目录前缀
+	namespaceDirPrefix = "namespace-"
+)
+
+// Namespace 命名空间，通过 DB.CreateNamespace 或者 DB.Namespace 获取
+type Namespace struct {
+	db      *DB
+	name    string
+	id      uint32
+	index   index.Indexer // 命名空间的内存索引
+	dropped atomic.Bool   // 是否已经被删除
+}
+
+// NamespaceStat 命名空间的统计信息
+type NamespaceStat struct {
+	Name     string // 命名空间的名称
+	KeyNum   uint   // key 的数量
+	DataSize int64  // 有效数据在数据文件中占用的空间，字节为单位
+}
+
+// CreateNamespace 创建命名空间，已经存在时返回 ErrNamespaceExists
+func (db *DB) CreateNamespace(name string) (*Namespace, error) {
+	if name == "" {
+		return nil, ErrNamespaceNameEmpty
+	}
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.namespaceByName(name) != nil {
+		return nil, ErrNamespaceExists
+	}
+	id := db.nextNamespaceId
+	idx, err := db.newNamespaceIndex(id)
+	if err != nil {
+		return nil, err
+	}
+	ns := &Namespace{db: db, name: name, id: id, index: idx}
+
+	// 命名空间持久化之后才能写入记录，id 不会被重复使用
+	db.namespaces[id] = ns
+	db.nextNamespaceId++
+	if err := db.saveNamespaces(); err != nil {
+		delete(db.namespaces, id)
+		db.nextNamespaceId--
+		_ = idx.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Namespace 获取已经存在的命名空间，不存在时返回 ErrNamespaceNotFound
+func (db *DB) Namespace(name string) (*Namespace, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if ns := db.namespaceByName(name); ns != nil {
+		return ns, nil
+	}
+	return nil, ErrNamespaceNotFound
+}
+
+// ListNamespaces 获取所有命名空间的名称，不包括默认的命名空间
+func (db *DB) ListNamespaces() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := make([]string, 0, len(db.namespaces))
+	for _, ns := range db.namespaces {
+		names = append(names, ns.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropNamespace 删除命名空间和其中所有的数据
+// 只需要更新 namespaces 文件，数据文件中的记录在 merge 时回收，之前获取的 Namespace 不能再使用
+// 删除之前需要关闭命名空间上的迭代器
+func (db *DB) DropNamespace(name string) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	ns := db.namespaceByName(name)
+	if ns == nil {
+		db.mu.Unlock()
+		return ErrNamespaceNotFound
+	}
+	delete(db.namespaces, ns.id)
+	if err := db.saveNamespaces(); err != nil {
+		db.namespaces[ns.id] = ns
+		db.mu.Unlock()
+		return err
+	}
+	ns.dropped.Store(true)
+	// 命名空间中所有的记录都可以回收
+	iterator := ns.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		db.reclaim(iterator.Value())
+	}
+	iterator.Close()
+	db.mu.Unlock()
+
+	// B+ 树关闭时会等待迭代器的读事务结束，不能持有锁
+	if err := ns.index.Close(); err != nil {
+		return err
+	}
+	if db.namespaceHasDir() {
+		return os.RemoveAll(db.namespaceDir(ns.id))
+	}
+	return nil
+}
+
+// Name 命名空间的名称
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Put 在命名空间中写入 Key/Value 数据，Key 不能为空
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	logRecord := newPutRecord(key, value, 0)
+	logRecord.Namespace = ns.id
+	return ns.db.commitWrite(func() (func(), error) {
+		if ns.dropped.Load() {
+			return nil, ErrNamespaceDropped
+		}
+		pos, err := ns.db.appendLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			if oldPos := ns.index.Put(key, pos); oldPos != nil {
+				ns.db.reclaim(oldPos)
+			}
+		}, nil
+	})
+}
+
+// Get 根据 Key 读取命名空间中的数据
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	if ns.dropped.Load() {
+		return nil, ErrNamespaceDropped
+	}
+	pos := ns.index.Get(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return ns.db.getValueByPosition(pos)
+}
+
+// Delete 根据 key 删除命名空间中的数据
+func (ns *Namespace) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	logRecord := newDeleteRecord(key)
+	logRecord.Namespace = ns.id
+	return ns.db.commitWrite(func() (func(), error) {
+		if ns.dropped.Load() {
+			return nil, ErrNamespaceDropped
+		}
+		// key 不存在时不需要写入
+		if ns.index.Get(key) == nil {
+			return func() {}, nil
+		}
+		pos, err := ns.db.appendLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			ns.db.reclaim(pos)
+			if oldPos, _ := ns.index.Delete(key); oldPos != nil {
+				ns.db.reclaim(oldPos)
+			}
+		}, nil
+	})
+}
+
+// NewIterator 初始化命名空间上的迭代器，使用完毕之后需要调用 Close
+func (ns *Namespace) NewIterator(opts IteratorOptions) (*Iterator, error) {
+	return ns.db.newIterator(opts, func() (index.Indexer, error) {
+		if ns.dropped.Load() {
+			return nil, ErrNamespaceDropped
+		}
+		return ns.index, nil
+	})
+}
+
+// Stat 返回命名空间的统计信息
+func (ns *Namespace) Stat() (*NamespaceStat, error) {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	if ns.dropped.Load() {
+		return nil, ErrNamespaceDropped
+	}
+	stat := &NamespaceStat{Name: ns.name, KeyNum: uint(ns.index.Size())}
+	iterator := ns.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		stat.DataSize += int64(pos.Size) + int64(pos.BlobSize)
+	}
+	return stat, nil
+}
+
+// 写入数据并更新内存索引，需要持久化时通过组提交和其他并发的写入一起持久化
+func (db *DB) commitWrite(write func() (func(), error)) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.options.SyncWrites {
+		return db.groupCommit(write)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	apply, err := write()
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// 根据命名空间 id 获取对应的内存索引，命名空间不存在或者已经删除时返回 nil，在访问方法之前必须持有互斥锁
+func (db *DB) indexOf(namespace uint32) index.Indexer {
+	if namespace == 0 {
+		return db.index
+	}
+	if ns := db.namespaces[namespace]; ns != nil {
+		return ns.index
+	}
+	return nil
+}
+
+// 根据名称查找命名空间，在访问方法之前必须持有互斥锁
+func (db *DB) namespaceByName(name string) *Namespace {
+	for _, ns := range db.namespaces {
+		if ns.name == name {
+			return ns
+		}
+	}
+	return nil
+}
+
+// 创建命名空间的内存索引，B+ 树索引和混合索引的文件放在单独的目录中
+func (db *DB) newNamespaceIndex(id uint32) (index.Indexer, error) {
+	dirPath := db.options.DirPath
+	if db.namespaceHasDir() {
+		dirPath = db.namespaceDir(id)
+		if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return index.NewIndexer(db.options.IndexType, dirPath, db.options.SyncWrites, db.options.IndexMemoryLimit), nil
+}
+
+// 命名空间的索引是否有单独的目录，索引有文件时需要
+func (db *DB) namespaceHasDir() bool {
+	return db.options.IndexType == BPlusTree || db.options.IndexType == Hybrid
+}
+
+// B+ 树索引和混合索引时命名空间的索引文件所在的目录
+func (db *DB) namespaceDir(id uint32) string {
+	return filepath.Join(db.options.DirPath, namespaceDirPrefix+strconv.FormatUint(uint64(id), 10))
+}
+
+// 将所有的命名空间写入 namespaces 文件，先写入临时文件再替换，在访问方法之前必须持有互斥锁
+func (db *DB) saveNamespaces() error {
+	tmpPath := filepath.Join(db.options.DirPath, data.NamespaceFileName+".tmp")
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	nsFile, err := data.OpenNamespaceFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	nsFile.Cipher = db.cipher
+	defer func() {
+		_ = nsFile.Close()
+	}()
+
+	// 命名空间的记录中 key 为名称，Namespace 为 id
+	records := []*data.LogRecord{{
+		Key:   []byte(namespaceNextIdKey),
+		Value: []byte(strconv.FormatUint(uint64(db.nextNamespaceId), 10)),
+	}}
+	for _, ns := range db.namespaces {
+		records = append(records, &data.LogRecord{Key: []byte(ns.name), Namespace: ns.id})
+	}
+	for _, record := range records {
+		if err := nsFile.WriteLogRecord(record); err != nil {
+			return err
+		}
+	}
+	if err := nsFile.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(db.options.DirPath, data.NamespaceFileName))
+}
+
+// 从 namespaces 文件中加载命名空间，并删除已经被删除的命名空间遗留的索引目录
+// 只读方式打开时刷新也会重新加载，已经加载的命名空间保持不变，文件中不存在的命名空间标记为删除
+func (db *DB) loadNamespaces() error {
+	db.nextNamespaceId = 1
+	loaded := make(map[uint32]bool)
+	fileName := filepath.Join(db.options.DirPath, data.NamespaceFileName)
+	if _, err := os.Stat(fileName); err == nil {
+		nsFile, err := data.OpenNamespaceFile(fileName)
+		if err != nil {
+			return err
+		}
+		nsFile.Cipher = db.cipher
+		defer func() {
+			_ = nsFile.Close()
+		}()
+
+		offset := nsFile.HeaderSize
+		for {
+			record, size, err := nsFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return fmt.Errorf("failed to load namespaces: %w", err)
+			}
+			offset += size
+
+			if record.Namespace == 0 {
+				if string(record.Key) == namespaceNextIdKey {
+					nextId, err := strconv.ParseUint(string(record.Value), 10, 32)
+					if err != nil {
+						return ErrDataDirectoryCorrupted
+					}
+					db.nextNamespaceId = uint32(nextId)
+				}
+				continue
+			}
+			loaded[record.Namespace] = true
+			if db.namespaces[record.Namespace] != nil {
+				continue
+			}
+			idx, err := db.newNamespaceIndex(record.Namespace)
+			if err != nil {
+				return err
+			}
+			db.namespaces[record.Namespace] = &Namespace{db: db, name: string(record.Key), id: record.Namespace, index: idx}
+		}
+	}
+	for id, ns := range db.namespaces {
+		if !loaded[id] {
+			delete(db.namespaces, id)
+			ns.dropped.Store(true)
+			_ = ns.index.Close()
+		}
+	}
+
+	if !db.namespaceHasDir() {
+		return nil
+	}
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), namespaceDirPrefix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(entry.Name(), namespaceDirPrefix), 10, 32)
+		if err == nil && db.namespaces[uint32(id)] != nil {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(db.options.DirPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 关闭所有命名空间的内存索引
+func (db *DB) closeNamespaces() error {
+	for _, ns := range db.namespaces {
+		if err := ns.index.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
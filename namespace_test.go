@@ -0,0 +1,246 @@
+package tinykv
+
+import (
+	"bytes"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Namespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	_, err = db.CreateNamespace("users")
+	assert.Equal(t, ErrNamespaceExists, err)
+	_, err = db.CreateNamespace("")
+	assert.Equal(t, ErrNamespaceNameEmpty, err)
+	orders, err := db.CreateNamespace("orders")
+	assert.Nil(t, err)
+	_, err = db.Namespace("not-exist")
+	assert.Equal(t, ErrNamespaceNotFound, err)
+	assert.Equal(t, []string{"orders", "users"}, db.ListNamespaces())
+
+	// 相同的 key 在不同的命名空间中互不影响
+	key := utils.GetTestKey(1)
+	assert.Nil(t, db.Put(key, []byte("default")))
+	assert.Nil(t, users.Put(key, []byte("users")))
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	_, err = orders.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, orders.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, orders.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, users.Delete(key))
+	_, err = users.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+
+	stat, err := orders.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(99), stat.KeyNum)
+	assert.True(t, stat.DataSize > 99*128)
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+	assert.Equal(t, uint(2), db.Stat().NamespaceNum)
+
+	iterator, err := orders.NewIterator(IteratorOptions{Prefix: []byte("bitcask-go-key-00000000")})
+	assert.Nil(t, err)
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		val, err := iterator.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, 9, count)
+
+	// 重启之后从数据文件中恢复各个命名空间的索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	orders, err = db.Namespace("orders")
+	assert.Nil(t, err)
+	stat, err = orders.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(99), stat.KeyNum)
+	users, err = db.Namespace("users")
+	assert.Nil(t, err)
+	_, err = users.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+
+	// merge 之后仍然可以读取
+	assert.Nil(t, db.Merge())
+	val, err = orders.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	orders, err = db.Namespace("orders")
+	assert.Nil(t, err)
+	stat, err = orders.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(99), stat.KeyNum)
+}
+
+func TestDB_DropNamespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-drop")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ns, err := db.CreateNamespace("logs")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, ns.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	reclaimable := db.Stat().ReclaimableSize
+
+	assert.Nil(t, db.DropNamespace("logs"))
+	assert.Equal(t, ErrNamespaceNotFound, db.DropNamespace("logs"))
+	assert.True(t, db.Stat().ReclaimableSize > reclaimable+100*128)
+	assert.Equal(t, uint(0), db.Stat().NamespaceNum)
+	_, err = ns.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrNamespaceDropped, err)
+	assert.Equal(t, ErrNamespaceDropped, ns.Put(utils.GetTestKey(0), []byte("v")))
+	_, err = ns.NewIterator(DefaultIteratorOptions)
+	assert.Equal(t, ErrNamespaceDropped, err)
+
+	// 同名的命名空间重新创建之后是空的，重启之后也不会加载删除之前的数据
+	ns, err = db.CreateNamespace("logs")
+	assert.Nil(t, err)
+	_, err = ns.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, ns.Put(utils.GetTestKey(0), []byte("new")))
+
+	reclaimable = db.Stat().ReclaimableSize
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimable, db.Stat().ReclaimableSize)
+	ns, err = db.Namespace("logs")
+	assert.Nil(t, err)
+	stat, err := ns.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), stat.KeyNum)
+	val, err := ns.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+}
+
+func TestDB_Namespace_WriteBatch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-batch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	a, err := db.CreateNamespace("a")
+	assert.Nil(t, err)
+	b, err := db.CreateNamespace("b")
+	assert.Nil(t, err)
+	assert.Nil(t, b.Put([]byte("k2"), []byte("old")))
+
+	// 同一个批次中写入多个命名空间
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k"), []byte("default")))
+	assert.Nil(t, wb.PutNamespace(a, []byte("k"), []byte("a")))
+	assert.Nil(t, wb.PutNamespace(b, []byte("k"), []byte("b")))
+	assert.Nil(t, wb.DeleteNamespace(b, []byte("k2")))
+	assert.Nil(t, wb.Commit())
+
+	check := func() {
+		for _, c := range []struct {
+			get  func([]byte) ([]byte, error)
+			want string
+		}{{db.Get, "default"}, {a.Get, "a"}, {b.Get, "b"}} {
+			val, err := c.get([]byte("k"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(c.want), val)
+		}
+		_, err := b.Get([]byte("k2"))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	check()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	a, _ = db.Namespace("a")
+	b, _ = db.Namespace("b")
+	check()
+
+	// 命名空间在提交之前被删除时整个批次都不会写入
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k"), []byte("changed")))
+	assert.Nil(t, wb.PutNamespace(b, []byte("k"), []byte("changed")))
+	assert.Nil(t, db.DropNamespace("b"))
+	assert.Equal(t, ErrNamespaceDropped, wb.Commit())
+	val, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+}
+
+func TestDB_Namespace_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ns, err := db.CreateNamespace("bptree")
+	assert.Nil(t, err)
+	value := bytes.Repeat([]byte("v"), 64)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, ns.Put(utils.GetTestKey(i), value))
+	}
+	dropped, err := db.CreateNamespace("dropped")
+	assert.Nil(t, err)
+	assert.Nil(t, dropped.Put(utils.GetTestKey(0), value))
+	assert.Nil(t, db.DropNamespace("dropped"))
+	_, err = os.Stat(filepath.Join(dir, "namespace-2"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	ns, err = db.Namespace("bptree")
+	assert.Nil(t, err)
+	val, err := ns.Get(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	_, err = db.Get(utils.GetTestKey(5))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -1,6 +1,10 @@
 package tinykv
 
-import "os"
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"os"
+	"time"
+)
 
 // Options 配置项结构体
 type Options struct {
@@ -24,6 +28,55 @@ type Options struct {
 
 	// 数据文件合并的阈值
 	DataFileMergeRatio float32
+
+	// 后台自动 merge 的检查间隔，可回收空间的比例达到 DataFileMergeRatio 时自动 merge
+	// 为 0 时不开启自动 merge
+	AutoMergeInterval time.Duration
+
+	// 允许自动 merge 的时间窗口，为距离当天零点的时长，例如 2h 表示凌晨两点
+	// 开始和结束相同时表示不限制时间，开始大于结束时表示跨越零点
+	AutoMergeWindowStart time.Duration
+	AutoMergeWindowEnd   time.Duration
+
+	// 启动时遇到损坏记录的处理方式，默认直接返回错误
+	RecoveryMode RecoveryMode
+
+	// value 的压缩算法，只影响新写入的数据，不同压缩算法写入的数据可以同时读取
+	// merge 时会按照当前的配置重新压缩
+	Compression CompressionType
+
+	// 加密使用的密钥，为空时不加密，数据文件、hint 文件、merge 完成文件和事务序列号文件都会使用 AES-GCM 加密
+	// 更换密钥之后，新写入的数据使用新的密钥加密，merge 时使用新的密钥重新加密所有数据
+	// 包含旧密钥加密的记录的 blob 文件也会在 merge 时重写
+	// 注意 B+ 树索引文件中的 key 没有加密
+	Encryption KeyProvider
+
+	// value 的长度达到阈值时单独写入 blob 文件，数据文件中只保存 blob 文件中的位置，为 0 时不分离
+	// 数据文件 merge 时不需要重写这些 value，blob 文件通过 MergeBlobs 单独回收空间
+	BlobThreshold int64
+
+	// blob 文件的大小
+	BlobFileSize int64
+
+	// blob 文件合并的阈值，blob 文件中可回收空间的比例达到阈值时才会执行 MergeBlobs
+	BlobMergeRatio float32
+
+	// Watch 订阅者缓冲的事件数量，为 0 时使用默认的 1024
+	WatchBufferSize int
+
+	// 订阅者的缓冲区写满之后的处理方式，默认丢弃最早的事件
+	WatchOverflow WatchOverflowPolicy
+
+	// 以只读方式打开，可以和写入的实例以及其他只读实例同时打开同一个目录
+	// 只读实例不会创建和修改任何数据文件，写入和 merge 返回 ErrReadOnly，通过 DB.Refresh 加载新写入的数据
+	// 不支持 B+ 树索引和混合索引
+	ReadOnly bool
+
+	// 打开时根据数据文件构建的二级索引，key 为索引的名称，也可以打开之后通过 DB.CreateIndex 创建
+	SecondaryIndexes map[string]IndexExtractor
+
+	// 混合索引在内存中最多占用的字节数，超过之后将最久没有访问的 key 溢出到磁盘上，每个命名空间的索引单独计算
+	IndexMemoryLimit int64
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -32,6 +85,19 @@ type IteratorOptions struct {
 	Prefix []byte
 	// 是否反向遍历，默认 false 是正向
 	Reverse bool
+	// 遍历的下界，为空时没有下界，默认包含下界本身
+	LowerBound []byte
+	// 遍历的上界，为空时没有上界，默认不包含上界本身
+	UpperBound []byte
+	// 是否排除下界本身
+	LowerExclusive bool
+	// 是否包含上界本身
+	UpperInclusive bool
+	// 只遍历 key，不读取数据文件，调用 Value 时返回 ErrIteratorKeysOnly
+	KeysOnly bool
+	// 预读 value 使用的并发数，为 0 时不预读
+	// 每次取出后续的一批 key，按照文件和偏移排序之后并发读取 value
+	PrefetchValues int
 }
 
 // WriteBatchOptions 批量提交配置项
@@ -56,8 +122,45 @@ const (
 
 	// BPlusTree B+树索引，将索引存储到磁盘上
 	BPlusTree
+
+	// Hash 分片的哈希索引，点查的性能更好，迭代时需要对所有的 key 排序
+	Hash
+
+	// Hybrid 混合索引，内存占用超过 IndexMemoryLimit 时将不常访问的 key 溢出到磁盘上，适合内存放不下所有 key 的场景
+	Hybrid
+)
+
+// RecoveryMode 启动恢复模式
+type RecoveryMode = int8
+
+const (
+	// RecoveryStrict 遇到损坏的记录直接返回错误
+	RecoveryStrict RecoveryMode = iota
+
+	// RecoveryTruncateTail 截断活跃文件尾部损坏的数据，通常是写入过程中进程崩溃导致的
+	RecoveryTruncateTail
+
+	// RecoverySkipCorrupt 在截断活跃文件尾部的基础上，跳过旧数据文件中损坏的记录
+	RecoverySkipCorrupt
 )
 
+// CompressionType value 的压缩算法，自定义的算法通过 data.RegisterCompressor 注册
+type CompressionType = data.CompressionType
+
+const (
+	// NoCompression 不压缩
+	NoCompression = data.CompressionNone
+
+	// SnappyCompression snappy 压缩，速度快
+	SnappyCompression = data.CompressionSnappy
+
+	// ZstdCompression zstd 压缩，压缩率高
+	ZstdCompression = data.CompressionZstd
+)
+
+// KeyProvider 提供加密使用的密钥，可以使用 data.StaticKeyProvider
+type KeyProvider = data.KeyProvider
+
 // DefaultOptions 默认选项
 var DefaultOptions = Options{
 	DirPath:            os.TempDir(),
@@ -67,6 +170,14 @@ var DefaultOptions = Options{
 	BytesPerSync:       0,
 	MMapAtStartup:      true,
 	DataFileMergeRatio: 0.5,
+	RecoveryMode:       RecoveryStrict,
+	Compression:        NoCompression,
+	BlobThreshold:      0,
+	BlobFileSize:       256 * 1024 * 1024, // 256MB
+	BlobMergeRatio:     0.5,
+	WatchBufferSize:    defaultWatchBufferSize,
+	WatchOverflow:      WatchDropOldest,
+	IndexMemoryLimit:   256 * 1024 * 1024, // 256MB
 }
 
 // DefaultIteratorOptions 默认迭代器选项
@@ -0,0 +1,200 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/index"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 只读方式打开
+// 只读实例持有 flock-reader 文件的共享锁，可以和写入的实例以及其他只读实例同时打开同一个目录，
+// 需要独占整个目录的工具（例如 tinykv-check --repair）通过这个文件的排它锁等待只读实例退出
+// 只读实例以只读的方式打开数据文件，写入的实例追加的数据通过 Refresh 加载
+
+// Refresh 加载写入的实例在上一次加载之后追加的数据，只在只读方式打开时有效，写入模式下直接返回
+// 写入的实例 merge 之后数据文件被替换，刷新时重新加载全部的数据文件和索引
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.watchers == nil {
+		return ErrDatabaseClosed
+	}
+	// 刷新期间索引可能指向还没有打开的文件，读取都在锁内进行
+	db.beginRemapFiles()
+	defer db.endRemapFiles()
+
+	mergeFileId, err := db.loadMergeFileId()
+	if err != nil {
+		return err
+	}
+	if mergeFileId != db.mergeFileId {
+		if err := db.reload(); err != nil {
+			return err
+		}
+		return db.rebuildSecondaryIndexes()
+	}
+
+	// 记录重放过的 key，只更新这些 key 的二级索引
+	if len(db.secondaryIndexes) > 0 {
+		db.refreshedKeys = make(map[string]struct{})
+		defer func() {
+			db.refreshedKeys = nil
+		}()
+	}
+
+	// 新创建的命名空间需要在重放记录之前加载
+	if err := db.loadNamespaces(); err != nil {
+		return err
+	}
+	fileIds, err := db.listDataFileIds()
+	if err != nil {
+		return err
+	}
+	// 出现新的数据文件时，当前的活跃文件已经写完了，先读完剩余的部分
+	if db.activeFile != nil {
+		if err := db.refreshDataFile(db.activeFile); err != nil {
+			return err
+		}
+	}
+	for _, fid := range fileIds {
+		if db.activeFile != nil && uint32(fid) <= db.activeFile.FileId {
+			continue
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), db.fileIOType())
+		if err != nil {
+			return err
+		}
+		dataFile.Cipher = db.cipher
+		if db.activeFile != nil {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+		if err := db.refreshDataFile(dataFile); err != nil {
+			return err
+		}
+	}
+
+	// 数据文件中的 blob 记录写入之前 blob 文件已经创建，在数据文件之后查找
+	if err := db.refreshBlobFiles(); err != nil {
+		return err
+	}
+	// blob 文件打开之后才能读取重放过的 key 的 value
+	return db.refreshSecondaryIndexes()
+}
+
+// 从上一次读取到的位置继续读取数据文件中的记录，并更新到内存索引中，在访问方法之前必须持有互斥锁
+// 写入的实例可能正在写入最后一条记录，读取不完整时停在这条记录之前，下一次刷新时继续读取
+func (db *DB) refreshDataFile(dataFile *data.DataFile) error {
+	now := time.Now().UnixNano()
+	offset := dataFile.WriteOff
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF || isCorruptRecordErr(err) {
+				break
+			}
+			return err
+		}
+		db.replayLogRecord(logRecord, dataFile.FileId, offset, size, now)
+		offset += size
+	}
+	dataFile.WriteOff = offset
+	return nil
+}
+
+// 打开写入的实例新创建的 blob 文件，在访问方法之前必须持有互斥锁
+func (db *DB) refreshBlobFiles() error {
+	fileIds, err := listBlobFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		if db.blobFiles[uint32(fid)] != nil {
+			continue
+		}
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, uint32(fid), db.fileIOType())
+		if err != nil {
+			return err
+		}
+		blobFile.Cipher = db.cipher
+		db.blobFiles[uint32(fid)] = blobFile
+	}
+	return nil
+}
+
+// 重新加载数据目录，使用新加载的数据文件和索引替换当前的状态，在访问方法之前必须持有互斥锁
+// 全部加载完成之后才替换，加载失败时当前的状态保持不变
+// 被快照和迭代器引用的数据文件在引用释放之后关闭，已经获取的命名空间继续有效
+func (db *DB) reload() error {
+	// 只用来加载磁盘上的状态，订阅者、二级索引和文件锁仍然使用当前实例的
+	fresh := &DB{
+		options:    db.options,
+		olderFiles: make(map[uint32]*data.DataFile),
+		index:      index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites, db.options.IndexMemoryLimit),
+		blobFiles:  make(map[uint32]*data.DataFile),
+		namespaces: make(map[uint32]*Namespace),
+		cipher:     db.cipher,
+	}
+	if err := fresh.loadFilesAndIndex(); err != nil {
+		fresh.closeFilesAndIndex()
+		return err
+	}
+
+	for _, file := range db.olderFiles {
+		db.retireDataFile(file)
+	}
+	if db.activeFile != nil {
+		db.retireDataFile(db.activeFile)
+	}
+	for _, file := range db.blobFiles {
+		db.retireDataFile(file)
+	}
+	_ = db.index.Close()
+
+	// 已经获取的命名空间改为使用新加载的索引
+	for id, ns := range fresh.namespaces {
+		ns.db = db
+		if old := db.namespaces[id]; old != nil {
+			_ = old.index.Close()
+			old.index = ns.index
+			fresh.namespaces[id] = old
+		}
+	}
+	for id, old := range db.namespaces {
+		if fresh.namespaces[id] != old {
+			old.dropped.Store(true)
+			_ = old.index.Close()
+		}
+	}
+
+	db.index = fresh.index
+	db.activeFile = fresh.activeFile
+	db.olderFiles = fresh.olderFiles
+	db.fileIds = fresh.fileIds
+	db.blobFiles = fresh.blobFiles
+	db.activeBlobFile = fresh.activeBlobFile
+	db.namespaces = fresh.namespaces
+	db.nextNamespaceId = fresh.nextNamespaceId
+	db.pendingTxns = fresh.pendingTxns
+	db.mergeFileId = fresh.mergeFileId
+	db.seqNo = fresh.seqNo
+	db.reclaimableSize = fresh.reclaimableSize
+	db.blobReclaimableSize = fresh.blobReclaimableSize
+	db.recoveryReport = fresh.recoveryReport
+	return nil
+}
+
+// 读取最近一次 merge 的 nonMergeFileId，没有 merge 过时返回 0
+func (db *DB) loadMergeFileId() (uint32, error) {
+	fileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return 0, nil
+	}
+	return db.getNonMergeFileId(db.options.DirPath)
+}
@@ -0,0 +1,220 @@
+package tinykv
+
+import (
+	"encoding/json"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Sync())
+	files := listFileNames(dir)
+
+	// 可以和写入的实例以及其他只读实例同时打开
+	readOnlyOpts := opts
+	readOnlyOpts.ReadOnly = true
+	reader, err := Open(readOnlyOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	other, err := Open(readOnlyOpts)
+	assert.Nil(t, err)
+	assert.Nil(t, other.Close())
+	// 只读实例没有创建和修改任何文件
+	assert.Equal(t, files, listFileNames(dir))
+
+	assert.Equal(t, uint(100), reader.Stat().KeyNum)
+	val, err := reader.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	assert.Equal(t, ErrReadOnly, reader.Put(utils.GetTestKey(1), []byte("v")))
+	assert.Equal(t, ErrReadOnly, reader.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrReadOnly, reader.Merge())
+	_, err = reader.CompareAndSwap(utils.GetTestKey(10), val, []byte("v"))
+	assert.Equal(t, ErrReadOnly, err)
+	_, err = reader.DeletePrefix([]byte("bitcask"))
+	assert.Equal(t, ErrReadOnly, err)
+	wb := reader.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("v")))
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+	_, err = reader.CreateNamespace("ns")
+	assert.Equal(t, ErrReadOnly, err)
+
+	// 写入的实例追加的数据刷新之后可以读取到，包括新的数据文件和事务
+	for i := 100; i < 400; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("value")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+	ns, err := db.CreateNamespace("ns")
+	assert.Nil(t, err)
+	assert.Nil(t, ns.Put([]byte("k"), []byte("ns-value")))
+	assert.Nil(t, db.Sync())
+
+	_, err = reader.Get(utils.GetTestKey(200))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, reader.Refresh())
+	check := func(reader *DB) {
+		_, err := reader.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = reader.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+		for i := 2; i < 400; i++ {
+			val, err := reader.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.NotNil(t, val)
+		}
+		val, err := reader.Get([]byte("batch"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+		ns, err := reader.Namespace("ns")
+		assert.Nil(t, err)
+		val, err = ns.Get([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("ns-value"), val)
+	}
+	check(reader)
+	assert.Equal(t, uint(399), reader.Stat().KeyNum)
+
+	// 写入的实例 merge 之后重新加载
+	iterator := reader.NewIterator(DefaultIteratorOptions)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put([]byte("after-merge"), []byte("value")))
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, reader.Refresh())
+	val, err = reader.Get([]byte("after-merge"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	check(reader)
+	assert.Equal(t, uint(400), reader.Stat().KeyNum)
+
+	// 刷新之前创建的迭代器仍然可以读取
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		_, err := iterator.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, 399, count)
+
+	// 关闭时不写入事务序列号文件
+	files = listFileNames(dir)
+	assert.Nil(t, reader.Close())
+	assert.Equal(t, files, listFileNames(dir))
+}
+
+func TestDB_ReadOnly_Options(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(os.TempDir(), "bitcask-go-readonly-not-exist")
+	opts.ReadOnly = true
+	_, err := Open(opts)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_ReadOnly_SecondaryIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-secondary-index")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.BlobThreshold = 256
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("user:1"), userValue("a@example.com")))
+	assert.Nil(t, db.Put([]byte("user:2"), userValue("b@example.com")))
+	assert.Nil(t, db.Sync())
+
+	readOnlyOpts := opts
+	readOnlyOpts.ReadOnly = true
+	// 记录提取二级索引的次数，重新加载时只重建一次二级索引
+	var extracted int
+	readOnlyOpts.SecondaryIndexes = map[string]IndexExtractor{"email": func(key, value []byte) [][]byte {
+		extracted++
+		return emailExtractor(key, value)
+	}}
+	reader, err := Open(readOnlyOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	query := func(email string) []string {
+		keys, err := reader.QueryIndex("email", []byte(email))
+		assert.Nil(t, err)
+		var result []string
+		for _, key := range keys {
+			result = append(result, string(key))
+		}
+		sort.Strings(result)
+		return result
+	}
+	assert.Equal(t, []string{"user:1"}, query("a@example.com"))
+	assert.Equal(t, []string{"user:2"}, query("b@example.com"))
+
+	// 刷新之后重放的记录更新到二级索引中，包括事务中的记录和存放在 blob 文件中的 value
+	assert.Nil(t, db.Put([]byte("user:1"), userValue("c@example.com")))
+	assert.Nil(t, db.Delete([]byte("user:2")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:3"), userValue("a@example.com")))
+	assert.Nil(t, wb.Commit())
+	blobValue, _ := json.Marshal(map[string]string{"email": "a@example.com", "bio": string(utils.RandomValue(512))})
+	assert.Nil(t, db.Put([]byte("user:4"), blobValue))
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, reader.Refresh())
+	assert.Equal(t, []string{"user:3", "user:4"}, query("a@example.com"))
+	assert.Nil(t, query("b@example.com"))
+	assert.Equal(t, []string{"user:1"}, query("c@example.com"))
+
+	// merge 之后重新加载时重建二级索引
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put([]byte("user:5"), userValue("c@example.com")))
+	assert.Nil(t, db.Sync())
+	extracted = 0
+	assert.Nil(t, reader.Refresh())
+	assert.Equal(t, 4, extracted)
+	assert.Equal(t, []string{"user:3", "user:4"}, query("a@example.com"))
+	assert.Equal(t, []string{"user:1", "user:5"}, query("c@example.com"))
+}
+
+// 数据目录中的文件名称，不包括只读实例的文件锁
+func listFileNames(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != readerLockName {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
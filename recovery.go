@@ -0,0 +1,100 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/fio"
+	"io"
+	"os"
+)
+
+// CorruptRecord 启动恢复时丢弃的一段损坏数据
+type CorruptRecord struct {
+	Fid    uint32 // 所在的数据文件 id
+	Offset int64  // 在数据文件中的偏移
+	Size   int64  // 丢弃的字节数
+	Err    error  // 损坏的原因
+}
+
+// RecoveryReport 启动恢复的结果
+type RecoveryReport struct {
+	Truncated []CorruptRecord // 活跃文件尾部被截断的数据
+	Skipped   []CorruptRecord // 旧数据文件中被跳过的损坏记录
+}
+
+// RecoveryReport 返回打开数据库时恢复丢弃的数据，严格模式下始终为空
+func (db *DB) RecoveryReport() RecoveryReport {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return RecoveryReport{
+		Truncated: append([]CorruptRecord(nil), db.recoveryReport.Truncated...),
+		Skipped:   append([]CorruptRecord(nil), db.recoveryReport.Skipped...),
+	}
+}
+
+// 判断是否是记录损坏导致的错误
+func isCorruptRecordErr(err error) bool {
+	return err == data.ErrInvalidCRC || err == io.ErrUnexpectedEOF
+}
+
+// 按照恢复模式处理加载索引时遇到的损坏记录
+// 返回需要跳过的长度，为 0 时说明文件剩余的部分已经被丢弃，不能处理时返回原来的错误
+func (db *DB) recoverCorruptRecord(dataFile *data.DataFile, offset, size int64, err error) (int64, error) {
+	if !isCorruptRecordErr(err) {
+		return 0, err
+	}
+	// 只读方式打开时活跃文件尾部可能是写入的实例正在写入的记录，停在这里，刷新时继续读取
+	if db.options.ReadOnly && dataFile == db.activeFile {
+		return 0, nil
+	}
+	if db.options.RecoveryMode == RecoveryStrict {
+		return 0, err
+	}
+	fileSize, sizeErr := dataFile.IoManager.Size()
+	if sizeErr != nil {
+		return 0, sizeErr
+	}
+
+	// 活跃文件从损坏的位置开始全部截断
+	if dataFile == db.activeFile {
+		if err := db.truncateActiveFile(offset); err != nil {
+			return 0, err
+		}
+		db.recoveryReport.Truncated = append(db.recoveryReport.Truncated, CorruptRecord{
+			Fid: dataFile.FileId, Offset: offset, Size: fileSize - offset, Err: err,
+		})
+		return 0, nil
+	}
+
+	if db.options.RecoveryMode != RecoverySkipCorrupt {
+		return 0, err
+	}
+	// 旧数据文件不做修改，无法确定记录的长度时丢弃文件剩余的部分
+	if size <= 0 {
+		size = fileSize - offset
+	}
+	db.recoveryReport.Skipped = append(db.recoveryReport.Skipped, CorruptRecord{
+		Fid: dataFile.FileId, Offset: offset, Size: size, Err: err,
+	})
+	if offset+size >= fileSize {
+		return 0, nil
+	}
+	return size, nil
+}
+
+// 将活跃文件截断到指定的长度，截断之后使用标准文件 IO 重新打开
+func (db *DB) truncateActiveFile(size int64) error {
+	if err := db.activeFile.IoManager.Close(); err != nil {
+		return err
+	}
+	fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+	if err := os.Truncate(fileName, size); err != nil {
+		return err
+	}
+	ioManager, err := fio.NewFileIOManager(fileName)
+	if err != nil {
+		return err
+	}
+	db.activeFile.IoManager = ioManager
+	db.activeFile.WriteOff = size
+	return nil
+}
@@ -0,0 +1,144 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+// 活跃文件尾部有不完整的记录
+func TestOpen_RecoveryTruncateTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-truncate")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 模拟写入过程中进程崩溃
+	fileName := data.GetDataFileName(dir, 0)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	encRecord, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(100), nonTransactionSeqNo),
+		Value: utils.RandomValue(128),
+	})
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(encRecord[:size-10])
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	// 严格模式下无法打开
+	_, err = Open(opts)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	opts.RecoveryMode = RecoveryTruncateTail
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2)
+
+	report := db2.RecoveryReport()
+	assert.Equal(t, 0, len(report.Skipped))
+	assert.Equal(t, 1, len(report.Truncated))
+	assert.Equal(t, uint32(0), report.Truncated[0].Fid)
+	assert.Equal(t, stat.Size(), report.Truncated[0].Offset)
+	assert.Equal(t, size-10, report.Truncated[0].Size)
+	assert.Equal(t, io.ErrUnexpectedEOF, report.Truncated[0].Err)
+
+	truncatedStat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.Size(), truncatedStat.Size())
+	assert.Equal(t, 100, len(db2.ListKeys()))
+
+	// 截断之后可以继续写入
+	err = db2.Put(utils.GetTestKey(100), utils.GetTestKey(100))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	opts.RecoveryMode = RecoveryStrict
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db3)
+	defer func() {
+		_ = db3.Close()
+	}()
+	assert.Equal(t, 0, len(db3.RecoveryReport().Truncated))
+	for i := 0; i <= 100; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+// 旧的数据文件中有损坏的记录
+func TestOpen_RecoverySkipCorrupt(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-skip")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.True(t, db.Stat().DataFileNum > 1)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 修改第一个数据文件中第一条记录的内容
+	_, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(0), nonTransactionSeqNo),
+		Value: utils.GetTestKey(0),
+	})
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("x"), data.FileHeaderSize+size-1)
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	// 只截断活跃文件的尾部，旧数据文件损坏时无法打开
+	opts.RecoveryMode = RecoveryTruncateTail
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	opts.RecoveryMode = RecoverySkipCorrupt
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2)
+
+	report := db2.RecoveryReport()
+	assert.Equal(t, 0, len(report.Truncated))
+	assert.Equal(t, 1, len(report.Skipped))
+	assert.Equal(t, CorruptRecord{Fid: 0, Offset: data.FileHeaderSize, Size: size, Err: data.ErrInvalidCRC}, report.Skipped[0])
+
+	_, err = db2.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 1; i < 5000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// merge 时同样跳过损坏的记录
+	err = db2.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, 4999, len(db2.ListKeys()))
+}
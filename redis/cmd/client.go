@@ -19,6 +19,7 @@ var supportedCommands = map[string]cmdHandler{
 	"set":   set,
 	"get":   get,
 	"hset":  hset,
+	"hmget": hmget,
 	"sadd":  sadd,
 	"lpush": lpush,
 	"zadd":  zadd,
@@ -98,6 +99,25 @@ func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	return redcon.SimpleInt(ok), nil
 }
 
+func hmget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hmget")
+	}
+
+	values, err := cli.db.HMGet(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	// 不存在的 field 返回 nil
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			res[i] = value
+		}
+	}
+	return res, nil
+}
+
 func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
 		return nil, newWrongNumberOfArgsError("sadd")
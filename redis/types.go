@@ -142,6 +142,36 @@ func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
 	return rds.db.Get(hk.encode())
 }
 
+// HMGet 批量读取 hash 中多个 field 的值，不存在的 field 对应的值为 nil
+func (rds *RedisDataStructure) HMGet(key []byte, fields ...[]byte) ([][]byte, error) {
+	values := make([][]byte, len(fields))
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 {
+		return values, nil
+	}
+
+	encKeys := make([][]byte, len(fields))
+	for i, field := range fields {
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKeys[i] = hk.encode()
+	}
+
+	values, errs := rds.db.MultiGet(encKeys)
+	for _, err := range errs {
+		if err != nil && err != tinykv.ErrKeyNotFound {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
 func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
 	meta, err := rds.findMetadata(key, Hash)
 	if err != nil {
@@ -92,6 +92,28 @@ func TestRedisDataStructure_HGet(t *testing.T) {
 	assert.Equal(t, tinykv.ErrKeyNotFound, err)
 }
 
+func TestRedisDataStructure_HMGet(t *testing.T) {
+	opts := tinykv.DefaultOptions
+	dir, _ := os.MkdirTemp("", "tinykv-go-redis-hmget")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	vals, err := rds.HMGet(utils.GetTestKey(1), []byte("field1"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{nil}, vals)
+
+	v1, v2 := utils.RandomValue(100), utils.RandomValue(100)
+	_, err = rds.HSet(utils.GetTestKey(1), []byte("field1"), v1)
+	assert.Nil(t, err)
+	_, err = rds.HSet(utils.GetTestKey(1), []byte("field2"), v2)
+	assert.Nil(t, err)
+
+	vals, err = rds.HMGet(utils.GetTestKey(1), []byte("field2"), []byte("field-not-exist"), []byte("field1"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{v2, nil, v1}, vals)
+}
+
 func TestRedisDataStructure_HDel(t *testing.T) {
 	opts := tinykv.DefaultOptions
 	dir, _ := os.MkdirTemp("", "tinykv-go-redis-hdel")
@@ -0,0 +1,237 @@
+package tinykv
+
+import (
+	"bytes"
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/index"
+	"time"
+)
+
+// 二级索引
+// 通过用户提供的 IndexExtractor 从 key/value 中提取词项，维护词项到主键的索引
+// 二级索引只保存在内存中，和主索引在同一把锁内更新，打开时根据数据文件重建，只包括默认命名空间中的数据
+
+// IndexExtractor 从 key/value 中提取二级索引的词项，返回空时这条数据不加入索引
+// 在持有数据库的锁时调用，不能调用 DB 的方法
+type IndexExtractor func(key, value []byte) [][]byte
+
+// 二级索引中不需要数据的位置，统一使用这个空的位置
+var secondaryIndexPos = &data.LogRecordPos{}
+
+// 一个二级索引
+type secondaryIndex struct {
+	extractor IndexExtractor
+	index     *index.BTree        // key 为词项和主键组成的复合 key
+	terms     map[string][][]byte // 主键当前对应的词项，更新和删除时用于移除旧的索引
+}
+
+// CreateIndex 创建二级索引，并根据现有的数据构建，已经存在时返回 ErrSecondaryIndexExists
+// 之后的 Put、Delete 和 WriteBatch 提交会同时更新二级索引
+func (db *DB) CreateIndex(name string, extractor IndexExtractor) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.secondaryIndexes[name]; ok {
+		return ErrSecondaryIndexExists
+	}
+	sidx, err := db.buildSecondaryIndex(extractor)
+	if err != nil {
+		return err
+	}
+	db.secondaryIndexes[name] = sidx
+	return nil
+}
+
+// DropIndex 删除二级索引
+func (db *DB) DropIndex(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	sidx, ok := db.secondaryIndexes[name]
+	if !ok {
+		return ErrSecondaryIndexNotFound
+	}
+	delete(db.secondaryIndexes, name)
+	return sidx.index.Close()
+}
+
+// QueryIndex 查找二级索引中词项为 term 的所有主键，按照主键排序
+func (db *DB) QueryIndex(name string, term []byte) ([][]byte, error) {
+	var keys [][]byte
+	prefix := encodeIndexTerm(term)
+	err := db.scanIndex(name, prefix, func(indexKey []byte) bool {
+		if !bytes.HasPrefix(indexKey, prefix) {
+			return false
+		}
+		keys = append(keys, append([]byte(nil), indexKey[len(prefix):]...))
+		return true
+	})
+	return keys, err
+}
+
+// ScanIndex 按照词项的顺序遍历二级索引中词项在 [start, end) 范围内的数据，fn 返回 false 时终止遍历
+// start 为空时从第一个词项开始，end 为空时遍历到最后一个词项
+// 遍历期间持有读锁，fn 中不能写入数据
+func (db *DB) ScanIndex(name string, start, end []byte, fn func(term []byte, key []byte) bool) error {
+	var upper []byte
+	if len(end) > 0 {
+		upper = encodeIndexTerm(end)
+	}
+	return db.scanIndex(name, encodeIndexTerm(start), func(indexKey []byte) bool {
+		if upper != nil && bytes.Compare(indexKey, upper) >= 0 {
+			return false
+		}
+		term, key := decodeIndexKey(indexKey)
+		return fn(term, key)
+	})
+}
+
+// 从 seek 开始遍历二级索引中的复合 key，跳过主索引中已经过期的 key
+func (db *DB) scanIndex(name string, seek []byte, fn func(indexKey []byte) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	sidx, ok := db.secondaryIndexes[name]
+	if !ok {
+		return ErrSecondaryIndexNotFound
+	}
+
+	now := time.Now().UnixNano()
+	sidx.index.AscendGreaterOrEqual(seek, func(indexKey []byte, _ *data.LogRecordPos) bool {
+		_, key := decodeIndexKey(indexKey)
+		if pos := db.index.Get(key); pos == nil || pos.IsExpired(now) {
+			return true
+		}
+		return fn(indexKey)
+	})
+	return nil
+}
+
+// 根据主索引中的数据构建二级索引，在访问方法之前必须持有互斥锁
+func (db *DB) buildSecondaryIndex(extractor IndexExtractor) (*secondaryIndex, error) {
+	sidx := &secondaryIndex{
+		extractor: extractor,
+		index:     index.NewBTree(),
+		terms:     make(map[string][][]byte),
+	}
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	now := time.Now().UnixNano()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired(now) {
+			continue
+		}
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return nil, err
+		}
+		sidx.put(iterator.Key(), value)
+	}
+	return sidx, nil
+}
+
+// 重新构建所有的二级索引，在访问方法之前必须持有互斥锁
+func (db *DB) rebuildSecondaryIndexes() error {
+	for name, sidx := range db.secondaryIndexes {
+		rebuilt, err := db.buildSecondaryIndex(sidx.extractor)
+		if err != nil {
+			return err
+		}
+		_ = sidx.index.Close()
+		db.secondaryIndexes[name] = rebuilt
+	}
+	return nil
+}
+
+// 只读实例刷新之后根据主索引更新重放过的 key 的二级索引，在访问方法之前必须持有互斥锁
+func (db *DB) refreshSecondaryIndexes() error {
+	now := time.Now().UnixNano()
+	for key := range db.refreshedKeys {
+		pos := db.index.Get([]byte(key))
+		if pos == nil || pos.IsExpired(now) {
+			db.deleteSecondaryIndexes([]byte(key))
+			continue
+		}
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		db.putSecondaryIndexes([]byte(key), value)
+	}
+	return nil
+}
+
+// 写入数据之后更新所有的二级索引，在访问方法之前必须持有互斥锁
+func (db *DB) putSecondaryIndexes(key, value []byte) {
+	for _, sidx := range db.secondaryIndexes {
+		sidx.delete(key)
+		sidx.put(key, value)
+	}
+}
+
+// 删除数据之后更新所有的二级索引，在访问方法之前必须持有互斥锁
+func (db *DB) deleteSecondaryIndexes(key []byte) {
+	for _, sidx := range db.secondaryIndexes {
+		sidx.delete(key)
+	}
+}
+
+// 提取词项并加入索引
+func (sidx *secondaryIndex) put(key, value []byte) {
+	terms := sidx.extractor(key, value)
+	if len(terms) == 0 {
+		return
+	}
+	copied := make([][]byte, len(terms))
+	for i, term := range terms {
+		copied[i] = append([]byte(nil), term...)
+		sidx.index.Put(encodeIndexKey(term, key), secondaryIndexPos)
+	}
+	sidx.terms[string(key)] = copied
+}
+
+// 移除主键之前加入的索引
+func (sidx *secondaryIndex) delete(key []byte) {
+	terms, ok := sidx.terms[string(key)]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		sidx.index.Delete(encodeIndexKey(term, key))
+	}
+	delete(sidx.terms, string(key))
+}
+
+// 编码词项，0x00 转义为 0x00 0xff，并以 0x00 0x01 结尾
+// 编码之后的顺序和词项的顺序一致，并且任何词项的编码都不是其他词项编码的前缀
+func encodeIndexTerm(term []byte) []byte {
+	buf := make([]byte, 0, len(term)+2)
+	for _, b := range term {
+		if b == 0x00 {
+			buf = append(buf, 0x00, 0xff)
+		} else {
+			buf = append(buf, b)
+		}
+	}
+	return append(buf, 0x00, 0x01)
+}
+
+// 二级索引中的复合 key：[编码之后的词项][主键]
+func encodeIndexKey(term, key []byte) []byte {
+	return append(encodeIndexTerm(term), key...)
+}
+
+// 从复合 key 中解析出词项和主键
+func decodeIndexKey(indexKey []byte) ([]byte, []byte) {
+	term := make([]byte, 0, len(indexKey))
+	for i := 0; i < len(indexKey); i++ {
+		if indexKey[i] != 0x00 || i+1 >= len(indexKey) {
+			term = append(term, indexKey[i])
+			continue
+		}
+		if indexKey[i+1] == 0x01 {
+			return term, indexKey[i+2:]
+		}
+		term = append(term, 0x00)
+		i++
+	}
+	return term, nil
+}
@@ -0,0 +1,134 @@
+package tinykv
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// 从用户的 JSON 中提取 email
+func emailExtractor(key, value []byte) [][]byte {
+	var user struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(value, &user); err != nil || user.Email == "" {
+		return nil
+	}
+	return [][]byte{[]byte(user.Email)}
+}
+
+func userValue(email string) []byte {
+	value, _ := json.Marshal(map[string]string{"email": email})
+	return value
+}
+
+func TestDB_SecondaryIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-secondary-index")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 创建之前写入的数据也会加入索引
+	assert.Nil(t, db.Put([]byte("user:1"), userValue("a@example.com")))
+	assert.Nil(t, db.Put([]byte("user:2"), userValue("b@example.com")))
+	assert.Nil(t, db.Put([]byte("other"), []byte("not json")))
+	assert.Nil(t, db.CreateIndex("email", emailExtractor))
+	assert.Equal(t, ErrSecondaryIndexExists, db.CreateIndex("email", emailExtractor))
+	_, err = db.QueryIndex("not-exist", []byte("a@example.com"))
+	assert.Equal(t, ErrSecondaryIndexNotFound, err)
+
+	keys, err := db.QueryIndex("email", []byte("a@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user:1")}, keys)
+
+	// 写入、覆盖、删除和批量提交都会更新索引
+	assert.Nil(t, db.Put([]byte("user:3"), userValue("a@example.com")))
+	assert.Nil(t, db.Put([]byte("user:1"), userValue("c@example.com")))
+	assert.Nil(t, db.Delete([]byte("user:2")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:4"), userValue("b@example.com")))
+	assert.Nil(t, wb.Delete([]byte("user:3")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.PutWithTTL([]byte("user:5"), userValue("d@example.com"), time.Millisecond))
+
+	check := func(db *DB) {
+		keys, err := db.QueryIndex("email", []byte("a@example.com"))
+		assert.Nil(t, err)
+		assert.Nil(t, keys)
+		keys, err = db.QueryIndex("email", []byte("b@example.com"))
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("user:4")}, keys)
+
+		// 范围扫描按照词项排序，跳过已经过期的 key
+		var terms, scanned []string
+		err = db.ScanIndex("email", []byte("b"), nil, func(term []byte, key []byte) bool {
+			terms = append(terms, string(term))
+			scanned = append(scanned, string(key))
+			return true
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"b@example.com", "c@example.com"}, terms)
+		assert.Equal(t, []string{"user:4", "user:1"}, scanned)
+	}
+	time.Sleep(2 * time.Millisecond)
+	check(db)
+
+	// 打开时根据数据文件重建配置中的二级索引
+	assert.Nil(t, db.Close())
+	opts.SecondaryIndexes = map[string]IndexExtractor{"email": emailExtractor}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	assert.Nil(t, db.DropIndex("email"))
+	assert.Equal(t, ErrSecondaryIndexNotFound, db.DropIndex("email"))
+}
+
+func TestDB_SecondaryIndex_Terms(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-secondary-index-terms")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// value 中的每个字节都是一个词项
+	assert.Nil(t, db.CreateIndex("bytes", func(key, value []byte) [][]byte {
+		var terms [][]byte
+		for i := range value {
+			terms = append(terms, value[i:i+1])
+		}
+		return terms
+	}))
+	assert.Nil(t, db.Put([]byte("k1"), []byte{0x00, 0x01}))
+	assert.Nil(t, db.Put([]byte("k2"), []byte{0x00}))
+	assert.Nil(t, db.Put([]byte("k3"), []byte{0x01, 0xff}))
+
+	// 包含 0x00 的词项和主键互不混淆
+	keys, err := db.QueryIndex("bytes", []byte{0x00})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("k1"), []byte("k2")}, keys)
+	keys, err = db.QueryIndex("bytes", []byte{0x01})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("k1"), []byte("k3")}, keys)
+
+	var terms [][]byte
+	err = db.ScanIndex("bytes", []byte{0x00}, []byte{0xff}, func(term []byte, key []byte) bool {
+		terms = append(terms, term)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{{0x00}, {0x00}, {0x01}, {0x01}}, terms)
+
+	for _, term := range [][]byte{{}, {0x00}, {0x00, 0x00}, {0x00, 0x01}, {0x01}, []byte("term")} {
+		decodedTerm, key := decodeIndexKey(encodeIndexKey(term, []byte("key")))
+		assert.Equal(t, term, decodedTerm)
+		assert.Equal(t, []byte("key"), key)
+	}
+}
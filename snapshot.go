@@ -0,0 +1,147 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"github.com/Nuyoahch/tinykv/index"
+	"sync"
+	"time"
+)
+
+// Snapshot 数据库某一时刻的只读视图
+// 快照创建之后的写入对其不可见，快照引用的数据文件在 Release 之前会一直保留
+type Snapshot struct {
+	db       *DB
+	mu       *sync.RWMutex
+	index    index.Indexer             // 创建快照时的索引副本
+	files    map[uint32]*data.DataFile // 快照引用的数据文件
+	blobs    map[uint32]*data.DataFile // 快照引用的 blob 文件
+	released bool                      // 是否已经释放
+}
+
+// NewSnapshot 创建一个当前时刻的快照，使用完毕之后需要调用 Release 释放
+func (db *DB) NewSnapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return &Snapshot{
+		db:    db,
+		mu:    new(sync.RWMutex),
+		index: db.cloneIndex(),
+		files: db.acquireDataFiles(),
+		blobs: db.acquireBlobFiles(),
+	}
+}
+
+// Get 根据 Key 读取快照中的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return getValueFromDataFile(s.files[logRecordPos.Fid], s.blobs, logRecordPos)
+}
+
+// NewIterator 初始化快照上的迭代器，需要在快照释放之前使用
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	iterator := &Iterator{
+		db:        s.db,
+		snapshot:  s,
+		indexIter: s.index.Iterator(opts.Reverse),
+		options:   opts,
+	}
+	iterator.init()
+	return iterator
+}
+
+// Release 释放快照，之后快照不能再使用
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+
+	s.db.mu.Lock()
+	s.db.releaseDataFiles(s.files)
+	s.db.releaseDataFiles(s.blobs)
+	s.db.mu.Unlock()
+
+	_ = s.index.Close()
+	s.files, s.blobs = nil, nil
+}
+
+// 根据索引信息从快照引用的数据文件中获取 value
+func (s *Snapshot) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	return getValueFromDataFile(s.files[logRecordPos.Fid], s.blobs, logRecordPos)
+}
+
+// 复制一份当前的内存索引，BTree 索引使用写时复制，混合索引只复制内存中的部分，其他索引逐条拷贝到新的 BTree 中
+// 在访问方法之前必须持有互斥锁
+func (db *DB) cloneIndex() index.Indexer {
+	switch idx := db.index.(type) {
+	case *index.BTree:
+		return idx.Clone()
+	case *index.HybridIndex:
+		return idx.Snapshot()
+	}
+	clone := index.NewBTree()
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		clone.Put(iterator.Key(), iterator.Value())
+	}
+	return clone
+}
+
+// 引用当前所有的数据文件，在访问方法之前必须持有互斥锁
+func (db *DB) acquireDataFiles() map[uint32]*data.DataFile {
+	files := make(map[uint32]*data.DataFile, len(db.olderFiles)+1)
+	for fid, file := range db.olderFiles {
+		files[fid] = file
+	}
+	if db.activeFile != nil {
+		files[db.activeFile.FileId] = db.activeFile
+	}
+	for _, file := range files {
+		db.fileRefs[file]++
+	}
+	return files
+}
+
+// 释放对数据文件的引用，已经被替换掉的数据文件在没有引用之后关闭，在访问方法之前必须持有互斥锁
+func (db *DB) releaseDataFiles(files map[uint32]*data.DataFile) {
+	for _, file := range files {
+		if db.fileRefs[file]--; db.fileRefs[file] > 0 {
+			continue
+		}
+		delete(db.fileRefs, file)
+		if _, ok := db.retiredFiles[file]; ok {
+			delete(db.retiredFiles, file)
+			_ = file.Close()
+		}
+	}
+}
+
+// 淘汰一个不再使用的数据文件，如果仍然被引用则延迟到释放时关闭，在访问方法之前必须持有互斥锁
+func (db *DB) retireDataFile(file *data.DataFile) {
+	if db.fileRefs[file] > 0 {
+		db.retiredFiles[file] = struct{}{}
+		return
+	}
+	_ = file.Close()
+}
@@ -0,0 +1,89 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	snap := db.NewSnapshot()
+
+	// 快照创建之后的写入、覆盖和删除对快照不可见
+	err = db.Put(utils.GetTestKey(0), []byte("new value"))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(100), utils.GetTestKey(100))
+	assert.Nil(t, err)
+
+	val0, err := snap.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(0), val0)
+	val1, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val1)
+	_, err = snap.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 数据库读取的是最新的数据
+	val2, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val2)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 快照上的迭代器
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), val)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	// 释放之后不能再读取
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	assert.Equal(t, 0, len(db.fileRefs))
+}
+
+func TestDB_NewSnapshot_ART(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-art")
+	opts.DirPath = dir
+	opts.IndexType = ART
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	snap := db.NewSnapshot()
+	defer snap.Release()
+	assert.Equal(t, 1, len(db.fileRefs))
+
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	val, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+}
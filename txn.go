@@ -0,0 +1,163 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/data"
+	"sync"
+)
+
+// Txn 乐观读写事务
+// 事务内的读取基于开始时刻的快照，写入先暂存在 WriteBatch 中，
+// 提交时如果读取过的 key 在事务开始之后被修改过，则提交失败
+type Txn struct {
+	db       *DB
+	mu       *sync.Mutex
+	snapshot *Snapshot                     // 事务开始时的快照
+	batch    *WriteBatch                   // 暂存事务内的写入
+	readSet  map[string]*data.LogRecordPos // 读取过的 key 及其在快照中的位置
+	finished bool                          // 是否已经提交或回滚
+}
+
+// Begin 开启一个新的事务，使用完毕之后需要调用 Commit 或者 Discard
+func (db *DB) Begin() *Txn {
+	batch := db.NewWriteBatch(DefaultWriteBatchOptions)
+	return &Txn{
+		db:       db,
+		mu:       new(sync.Mutex),
+		snapshot: db.NewSnapshot(),
+		batch:    batch,
+		readSet:  make(map[string]*data.LogRecordPos),
+	}
+}
+
+// Get 读取数据，优先读取事务内尚未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	// 事务内自己的写入
+	txn.batch.mu.Lock()
+	logRecord := txn.batch.pendingWrites[batchKey{key: string(key)}]
+	txn.batch.mu.Unlock()
+	if logRecord != nil {
+		if logRecord.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return logRecord.Value, nil
+	}
+
+	// 从快照中读取，并记录到读集合中用于提交时的冲突检测
+	txn.readSet[string(key)] = txn.snapshot.index.Get(key)
+	return txn.snapshot.Get(key)
+}
+
+// Put 在事务内写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	return txn.batch.Put(key, value)
+}
+
+// Delete 在事务内删除数据
+// 不能根据当前的索引判断 key 是否存在，快照中存在的 key 可能已经被其他写入删除，总是写入删除标记
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.batch.mu.Lock()
+	defer txn.batch.mu.Unlock()
+	txn.batch.pendingWrites[batchKey{key: string(key)}] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// Commit 提交事务，读取过的 key 在事务开始之后被修改过时返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	// 无论提交成功与否，事务都结束
+	defer txn.discard()
+
+	wb := txn.batch
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
+		return ErrExceedMaxBatchNum
+	}
+
+	// 需要持久化时和其他并发的写入一起组提交，冲突检测和写入在同一次加锁内完成
+	if len(wb.pendingWrites) > 0 && (wb.options.SyncWrites || txn.db.options.SyncWrites) {
+		return txn.db.groupCommit(func() (func(), error) {
+			if err := txn.checkConflict(); err != nil {
+				return nil, err
+			}
+			return wb.write()
+		})
+	}
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+
+	if err := txn.checkConflict(); err != nil {
+		return err
+	}
+	// 只读事务不需要写入
+	if len(wb.pendingWrites) == 0 {
+		return nil
+	}
+	return wb.commit()
+}
+
+// 冲突检测：读集合中 key 的位置和当前索引中的位置不一致，或者被同一组提交中之前的写入修改过，说明被其他写入修改过
+// 在访问方法之前必须持有互斥锁
+func (txn *Txn) checkConflict() error {
+	for key, pos := range txn.readSet {
+		if _, ok := txn.db.groupKeys[batchKey{key: key}]; ok {
+			return ErrTxnConflict
+		}
+		if !isSamePosition(pos, txn.db.index.Get([]byte(key))) {
+			return ErrTxnConflict
+		}
+	}
+	return nil
+}
+
+// Discard 回滚事务，丢弃所有未提交的写入
+func (txn *Txn) Discard() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return
+	}
+	txn.discard()
+}
+
+// 结束事务并释放快照，在访问方法之前必须持有 txn.mu
+func (txn *Txn) discard() {
+	txn.finished = true
+	txn.snapshot.Release()
+	txn.readSet = nil
+}
+
+// 判断两个位置索引是否指向同一条记录
+func isSamePosition(a, b *data.LogRecordPos) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Fid == b.Fid && a.Offset == b.Offset
+}
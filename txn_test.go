@@ -0,0 +1,223 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDB_Begin(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	txn := db.Begin()
+	// 读取到自己未提交的写入
+	err = txn.Put(utils.GetTestKey(2), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	val, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), val)
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 未提交之前对外不可见
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), val)
+
+	// 提交之后不能再使用
+	err = txn.Put(utils.GetTestKey(3), utils.GetTestKey(3))
+	assert.Equal(t, ErrTxnFinished, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+
+	// 重启之后数据依然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), val)
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-conflict")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+
+	// 1.读取过的 key 被并发修改，提交失败
+	txn1 := db.Begin()
+	val, err := txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+	err = db.Put(utils.GetTestKey(1), []byte("2"))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), []byte("3"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	// 2.事务开始之后、读取之前被修改，读取到的是快照中的值，同样冲突
+	txn2 := db.Begin()
+	err = db.Put(utils.GetTestKey(1), []byte("4"))
+	assert.Nil(t, err)
+	val, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 3.读取的 key 不存在，之后被其他写入创建
+	txn3 := db.Begin()
+	_, err = txn3.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.Put(utils.GetTestKey(2), []byte("1"))
+	assert.Nil(t, err)
+	err = txn3.Put(utils.GetTestKey(3), []byte("1"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 4.修改的是没有读取过的 key，不冲突
+	txn4 := db.Begin()
+	err = txn4.Put(utils.GetTestKey(5), []byte("1"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(5), []byte("2"))
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+
+	// 5.回滚之后写入被丢弃
+	txn5 := db.Begin()
+	err = txn5.Put(utils.GetTestKey(6), []byte("1"))
+	assert.Nil(t, err)
+	txn5.Discard()
+	err = txn5.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+	_, err = db.Get(utils.GetTestKey(6))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 0, len(db.fileRefs))
+}
+
+func TestTxn_Delete(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-delete")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 删除时 key 还不存在，提交之前被其他写入创建，提交之后同样被删除
+	txn := db.Begin()
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 快照中存在的 key 在删除之前已经被其他写入删除，之后又被重新写入
+	err = db.Put(utils.GetTestKey(2), []byte("1"))
+	assert.Nil(t, err)
+	txn = db.Begin()
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("2"))
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.Begin().Delete(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestTxn_SyncWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-sync")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte(strconv.Itoa(0)))
+	assert.Nil(t, err)
+
+	// 事务和其他写入一起组提交，同一组中之前的写入修改过读取的 key 时同样冲突，不会丢失更新
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for {
+					txn := db.Begin()
+					val, err := txn.Get(utils.GetTestKey(1))
+					assert.Nil(t, err)
+					n, _ := strconv.Atoi(string(val))
+					err = txn.Put(utils.GetTestKey(1), []byte(strconv.Itoa(n+1)))
+					assert.Nil(t, err)
+					err = txn.Commit()
+					if err == nil {
+						break
+					}
+					assert.Equal(t, ErrTxnConflict, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("400"), val)
+	assert.Equal(t, 0, len(db.fileRefs))
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -40,6 +41,11 @@ func AvailableDiskSize() (uint64, error) {
 
 // CopyDir 拷贝数据目录
 func CopyDir(src, dest string, exclude []string) error {
+	return CopyDirContext(context.Background(), src, dest, exclude)
+}
+
+// CopyDirContext 拷贝数据目录，拷贝每个文件之前检查 ctx，取消之后返回 ctx.Err()
+func CopyDirContext(ctx context.Context, src, dest string, exclude []string) error {
 	// 目标目标不存在则创建
 	if _, err := os.Stat(dest); os.IsNotExist(err) {
 		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
@@ -48,6 +54,9 @@ func CopyDir(src, dest string, exclude []string) error {
 	}
 
 	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		fileName := strings.Replace(path, src, "", 1)
 		if fileName == "" {
 			return nil
@@ -0,0 +1,169 @@
+package tinykv
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// WatchOp 变更事件的操作类型
+type WatchOp = byte
+
+const (
+	// WatchPut 写入数据
+	WatchPut WatchOp = iota + 1
+
+	// WatchDelete 删除数据
+	WatchDelete
+)
+
+// WatchOverflowPolicy 订阅者的缓冲区写满之后的处理方式
+type WatchOverflowPolicy = byte
+
+const (
+	// WatchDropOldest 丢弃缓冲区中最早的事件，保留最新的事件
+	WatchDropOldest WatchOverflowPolicy = iota
+
+	// WatchDropNewest 丢弃新的事件
+	WatchDropNewest
+
+	// WatchCloseOnOverflow 关闭订阅者的 channel，Err 返回 ErrWatcherOverflow
+	WatchCloseOnOverflow
+)
+
+// 没有设置 Options.WatchBufferSize 时订阅者缓冲的事件数量
+const defaultWatchBufferSize = 1024
+
+// WatchEvent 变更事件
+type WatchEvent struct {
+	Key   []byte
+	Op    WatchOp
+	Value []byte // 删除时为空
+	SeqNo uint64 // WriteBatch 和事务提交时的序列号，单独的写入为 0
+}
+
+// Watcher 订阅 key 前缀的变更，变更更新到内存索引之后按照提交的顺序发送到 C 中
+// 写入不会等待订阅者，缓冲区写满之后按照 Options.WatchOverflow 处理
+type Watcher struct {
+	C       <-chan *WatchEvent
+	ch      chan *WatchEvent
+	db      *DB
+	prefix  []byte
+	dropped atomic.Uint64 // 被丢弃的事件数量
+	err     atomic.Value  // 关闭的原因
+}
+
+// Watch 订阅以 prefix 开头的 key 的变更，prefix 为空时订阅所有的变更
+// 数据库关闭时会关闭所有订阅者的 channel，已经发送的事件仍然可以读取
+func (db *DB) Watch(prefix []byte) *Watcher {
+	bufferSize := db.options.WatchBufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultWatchBufferSize
+	}
+	ch := make(chan *WatchEvent, bufferSize)
+	w := &Watcher{
+		C:      ch,
+		ch:     ch,
+		db:     db,
+		prefix: append([]byte(nil), prefix...),
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.watchers == nil {
+		w.close(ErrDatabaseClosed)
+		return w
+	}
+	db.watchers[w] = struct{}{}
+	return w
+}
+
+// Close 取消订阅并关闭 channel
+func (w *Watcher) Close() {
+	w.db.mu.Lock()
+	defer w.db.mu.Unlock()
+	if _, ok := w.db.watchers[w]; ok {
+		delete(w.db.watchers, w)
+		w.close(nil)
+	}
+}
+
+// Dropped 因为缓冲区写满被丢弃的事件数量
+func (w *Watcher) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Err channel 被关闭的原因，缓冲区溢出时为 ErrWatcherOverflow，数据库关闭时为 ErrDatabaseClosed
+// 主动取消订阅或者没有关闭时为 nil
+func (w *Watcher) Err() error {
+	err, _ := w.err.Load().(error)
+	return err
+}
+
+// 关闭 channel，在访问方法之前必须持有互斥锁
+func (w *Watcher) close(err error) {
+	if err != nil {
+		w.err.Store(err)
+	}
+	close(w.ch)
+}
+
+// 发送变更事件给匹配的订阅者，在访问方法之前必须持有互斥锁
+func (db *DB) notify(op WatchOp, key []byte, value []byte, seqNo uint64) {
+	if len(db.watchers) == 0 {
+		return
+	}
+	var event *WatchEvent
+	for w := range db.watchers {
+		if !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		// 写入方可能会继续修改传入的数据，拷贝之后再发送
+		if event == nil {
+			event = &WatchEvent{
+				Key:   append([]byte(nil), key...),
+				Op:    op,
+				Value: append([]byte(nil), value...),
+				SeqNo: seqNo,
+			}
+		}
+		db.sendEvent(w, event)
+	}
+}
+
+// 发送事件给一个订阅者，缓冲区写满时按照配置处理，在访问方法之前必须持有互斥锁
+func (db *DB) sendEvent(w *Watcher, event *WatchEvent) {
+	select {
+	case w.ch <- event:
+		return
+	default:
+	}
+
+	switch db.options.WatchOverflow {
+	case WatchDropOldest:
+		// 只有持有锁时才会发送，腾出位置之后一定可以写入
+		select {
+		case <-w.ch:
+			w.dropped.Add(1)
+		default:
+		}
+		select {
+		case w.ch <- event:
+		default:
+			w.dropped.Add(1)
+		}
+	case WatchDropNewest:
+		w.dropped.Add(1)
+	case WatchCloseOnOverflow:
+		w.dropped.Add(1)
+		delete(db.watchers, w)
+		w.close(ErrWatcherOverflow)
+	}
+}
+
+// 关闭所有的订阅者，在访问方法之前必须持有互斥锁
+func (db *DB) closeWatchers() {
+	for w := range db.watchers {
+		w.close(ErrDatabaseClosed)
+	}
+	db.watchers = nil
+}
@@ -0,0 +1,138 @@
+package tinykv
+
+import (
+	"github.com/Nuyoahch/tinykv/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	watcher := db.Watch([]byte("config/"))
+	all := db.Watch(nil)
+
+	err = db.Put([]byte("config/a"), []byte("1"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("other"), []byte("2"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("config/a"))
+	assert.Nil(t, err)
+	// 不存在的 key 没有变更
+	err = db.Delete([]byte("config/not-exist"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("config/b"), []byte("3")))
+	assert.Nil(t, wb.Commit())
+
+	event := <-watcher.C
+	assert.Equal(t, &WatchEvent{Key: []byte("config/a"), Op: WatchPut, Value: []byte("1")}, event)
+	event = <-watcher.C
+	assert.Equal(t, []byte("config/a"), event.Key)
+	assert.Equal(t, WatchDelete, event.Op)
+	event = <-watcher.C
+	assert.Equal(t, []byte("config/b"), event.Key)
+	assert.Equal(t, WatchPut, event.Op)
+	assert.Equal(t, []byte("3"), event.Value)
+	assert.True(t, event.SeqNo > nonTransactionSeqNo)
+	assert.Equal(t, 0, len(watcher.C))
+	assert.Equal(t, 4, len(all.C))
+
+	// 取消订阅之后不再接收事件
+	watcher.Close()
+	_, ok := <-watcher.C
+	assert.False(t, ok)
+	assert.Nil(t, watcher.Err())
+	err = db.Put([]byte("config/c"), []byte("4"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(all.C))
+
+	// 关闭数据库之后仍然可以读取缓冲的事件
+	err = db.Close()
+	assert.Nil(t, err)
+	var count int
+	for range all.C {
+		count++
+	}
+	assert.Equal(t, 5, count)
+	assert.Equal(t, ErrDatabaseClosed, all.Err())
+	all.Close()
+}
+
+func TestDB_Watch_Overflow(t *testing.T) {
+	policies := []WatchOverflowPolicy{WatchDropOldest, WatchDropNewest, WatchCloseOnOverflow}
+	for _, policy := range policies {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-watch-overflow")
+		opts.DirPath = dir
+		opts.WatchBufferSize = 4
+		opts.WatchOverflow = policy
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		watcher := db.Watch(nil)
+		for i := 0; i < 10; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+			assert.Nil(t, err)
+		}
+
+		var keys [][]byte
+		switch policy {
+		case WatchDropOldest:
+			assert.Equal(t, uint64(6), watcher.Dropped())
+			for i := 0; i < 4; i++ {
+				keys = append(keys, (<-watcher.C).Key)
+			}
+			assert.Equal(t, [][]byte{utils.GetTestKey(6), utils.GetTestKey(7), utils.GetTestKey(8), utils.GetTestKey(9)}, keys)
+		case WatchDropNewest:
+			assert.Equal(t, uint64(6), watcher.Dropped())
+			for i := 0; i < 4; i++ {
+				keys = append(keys, (<-watcher.C).Key)
+			}
+			assert.Equal(t, [][]byte{utils.GetTestKey(0), utils.GetTestKey(1), utils.GetTestKey(2), utils.GetTestKey(3)}, keys)
+		case WatchCloseOnOverflow:
+			for event := range watcher.C {
+				keys = append(keys, event.Key)
+			}
+			assert.Equal(t, 4, len(keys))
+			assert.Equal(t, ErrWatcherOverflow, watcher.Err())
+		}
+		watcher.Close()
+		destroyDB(db)
+	}
+}
+
+func TestDB_Watch_DefaultBufferSize(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-default")
+	opts.DirPath = dir
+	// 没有设置缓冲区大小时使用默认值，不影响打开数据库
+	opts.WatchBufferSize = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	watcher := db.Watch(nil)
+	defer watcher.Close()
+	assert.Equal(t, defaultWatchBufferSize, cap(watcher.C))
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 10, len(watcher.C))
+	assert.Equal(t, uint64(0), watcher.Dropped())
+
+	opts.WatchBufferSize = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}